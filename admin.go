@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type StoreStats struct {
+	Users  int `json:"users"`
+	Events int `json:"events"`
+	// ApproxBytes is the size of users and events serialized as JSON. It is
+	// only an estimate of the memory actually held by the store.
+	ApproxBytes int `json:"approx_bytes"`
+}
+
+func adminStats(c echo.Context) error {
+	stats, err := getStore(c).getStats()
+	if err != nil {
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+func (s *Store) getStats() (*StoreStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usersSerialized, err := json.Marshal(s.users)
+	if err != nil {
+		return nil, err
+	}
+	eventsSerialized, err := json.Marshal(s.events)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoreStats{
+		Users:       len(s.users),
+		Events:      len(s.events),
+		ApproxBytes: len(usersSerialized) + len(eventsSerialized),
+	}, nil
+}
+
+type StoreDump struct {
+	Users   map[int64]*User  `json:"users"`
+	Events  []*Event         `json:"events"`
+	NextIDs map[string]int64 `json:"next_ids"`
+}
+
+func adminDump(c echo.Context) error {
+	serialized, err := getStore(c).dumpJSON()
+	if err != nil {
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, json.RawMessage(serialized))
+}
+
+func adminLoad(c echo.Context) error {
+	d := &StoreDump{}
+	err := c.Bind(d)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	err = getStore(c).load(d)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, "loaded")
+}
+
+// dumpJSON serializes the whole state. Users and events keep changing once
+// the lock is released, so they are marshaled while it is held.
+func (s *Store) dumpJSON() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return json.Marshal(s.snapshot())
+}
+
+// snapshot returns the state sharing the users and events of s, so the
+// caller must hold s.mu for as long as it uses it.
+func (s *Store) snapshot() *StoreDump {
+	return &StoreDump{
+		Users:  s.users,
+		Events: s.events,
+		NextIDs: map[string]int64{
+			"user":  s.nextUserID(),
+			"event": s.nextEventID(),
+		},
+	}
+}
+
+// load replaces the whole state with d. The next user id is derived from
+// the loaded users. The next event id is kept from d when it is higher than
+// the loaded events imply, so the ids of events dropped before the dump,
+// e.g. by an aborted batch, are not handed out again.
+func (s *Store) load(d *StoreDump) error {
+	if d.Users == nil {
+		d.Users = make(map[int64]*User)
+	}
+	if d.Events == nil {
+		d.Events = []*Event{}
+	}
+	for id, u := range d.Users {
+		if u == nil || u.ID != id {
+			return fmt.Errorf("user %d: id does not match its key", id)
+		}
+	}
+	for _, e := range d.Events {
+		if err := normalizePatches(e); err != nil {
+			return fmt.Errorf("event %d: %w", e.ID, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.backend != nil {
+		if err := s.backend.ReplaceAll(d.Users, d.Events); err != nil {
+			return err
+		}
+	}
+	s.users = d.Users
+	s.events = d.Events
+	s.lastEventID = maxEventID(d.Events)
+	if next := d.NextIDs["event"]; next-1 > s.lastEventID {
+		s.lastEventID = next - 1
+	}
+	s.resetVersions()
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestAdminStats(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+
+	rec := request(t, e, http.MethodGet, "/admin/stats", "")
+	expectStatus(t, rec, http.StatusOK)
+	stats := decodeBody[StoreStats](t, rec)
+	usersSerialized, _ := json.Marshal(s.users)
+	eventsSerialized, _ := json.Marshal(s.events)
+	if stats.Users != 1 || stats.Events != 1 || stats.ApproxBytes != len(usersSerialized)+len(eventsSerialized) {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestAdminDumpAndLoad(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+
+	rec := request(t, e, http.MethodGet, "/admin/dump", "")
+	expectStatus(t, rec, http.StatusOK)
+	d := decodeBody[StoreDump](t, rec)
+	if len(d.Users) != 1 || len(d.Events) != 1 || d.NextIDs["user"] != 2 || d.NextIDs["event"] != 2 {
+		t.Fatalf("dump has %d users, %d events, next ids %v", len(d.Users), len(d.Events), d.NextIDs)
+	}
+
+	loaded := NewStore()
+	le := newTestServer(loaded)
+	expectStatus(t, request(t, le, http.MethodPost, "/admin/load", rec.Body.String()), http.StatusOK)
+	if loaded.users[1].Name != "A" || len(loaded.events) != 1 {
+		t.Fatalf("loaded %+v, %d events", loaded.users[1], len(loaded.events))
+	}
+	// the patches of the loaded events still apply
+	rec = request(t, le, http.MethodGet, "/patch/rollback/1/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "John" {
+		t.Fatalf("rolled back loaded user = %+v", u)
+	}
+
+	expectStatus(t, request(t, le, http.MethodPost, "/admin/load", `{"users":{"1":{"id":2,"name":"B"}}}`), http.StatusBadRequest)
+	if loaded.users[1].Name != "A" {
+		t.Fatal("rejected dump replaced the store")
+	}
+}
+
+// TestAdminDumpConcurrentWrites is meant for go test -race: the dump must not
+// read the users while updates replace them.
+func TestAdminDumpConcurrentWrites(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			request(t, e, http.MethodPut, fmt.Sprintf("/user/update/%d", i+2), fmt.Sprintf(`{"id":%d,"name":"N","age":20}`, i+2))
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		expectStatus(t, request(t, e, http.MethodGet, "/admin/dump", ""), http.StatusOK)
+	}
+	wg.Wait()
+
+	d := decodeBody[StoreDump](t, request(t, e, http.MethodGet, "/admin/dump", ""))
+	if len(d.Users) != 51 || len(d.Events) != 50 || d.NextIDs["event"] != 51 {
+		t.Fatalf("dump has %d users, %d events, next ids %v", len(d.Users), len(d.Events), d.NextIDs)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Codes of the errors reported in APIError.
+const (
+	CodeBadRequest   = "bad_request"
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeNotFound     = "not_found"
+	CodeConflict     = "conflict"
+	CodeLocked       = "locked"
+	CodeRateLimited  = "rate_limited"
+	CodeTooLarge     = "too_large"
+	CodeInvalidInput = "invalid_input"
+	CodeInternal     = "internal"
+)
+
+// APIError is the body of every error response written by writeError.
+type APIError struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// writeError writes an APIError with the given code and message.
+func writeError(c echo.Context, status int, code, msg string) error {
+	return c.JSON(status, &APIError{Code: code, Message: msg, RequestID: getRequestID(c)})
+}
+
+// writeLookupError reports err as a 404 if it means that the looked up user
+// or event does not exist, as a 409 if replaying events found the state
+// drifted from the one they were recorded on, and as a 500 otherwise: the
+// request named things that exist, so the store failed to serve it.
+func writeLookupError(c echo.Context, err error) error {
+	if errors.Is(err, errUserNotFound) || errors.Is(err, errEventNotFound) {
+		return writeError(c, http.StatusNotFound, CodeNotFound, err.Error())
+	}
+	if errors.Is(err, errStateDrift) {
+		return writeError(c, http.StatusConflict, CodeConflict, err.Error())
+	}
+	return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+}
+
+// writeValidationError reports err, returned by validateUser, as a 422
+// listing the invalid fields.
+func writeValidationError(c echo.Context, err error) error {
+	apiErr := &APIError{Code: CodeInvalidInput, Message: err.Error(), RequestID: getRequestID(c)}
+	var fields FieldErrors
+	if errors.As(err, &fields) {
+		apiErr.Fields = fields
+	}
+	return c.JSON(http.StatusUnprocessableEntity, apiErr)
+}
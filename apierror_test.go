@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHandlerErrorsAreAPIErrors(t *testing.T) {
+	e := newTestServer(newSeededStore())
+
+	for _, tc := range []struct {
+		method, target, body string
+		status               int
+		code                 string
+	}{
+		{http.MethodGet, "/parse_date", "", http.StatusBadRequest, CodeBadRequest},
+		{http.MethodPost, "/user", `{"id":1,"name":"A","age":20}`, http.StatusConflict, CodeConflict},
+		{http.MethodGet, "/event/42", "", http.StatusNotFound, CodeNotFound},
+		{http.MethodPost, "/user/42/lock", "", http.StatusNotFound, CodeNotFound},
+		{http.MethodPost, "/user/1/dry-event", `{"id":2}`, http.StatusBadRequest, CodeBadRequest},
+		{http.MethodPost, "/reconstruct", `{}`, http.StatusBadRequest, CodeBadRequest},
+		{http.MethodGet, "/events/pages?limit=x", "", http.StatusBadRequest, CodeBadRequest},
+	} {
+		rec := request(t, e, tc.method, tc.target, tc.body)
+		expectStatus(t, rec, tc.status)
+		apiErr := decodeBody[APIError](t, rec)
+		if apiErr.Code != tc.code || apiErr.Message == "" || apiErr.RequestID == "" {
+			t.Errorf("%s %s: error = %+v, want code %s", tc.method, tc.target, apiErr, tc.code)
+		}
+	}
+}
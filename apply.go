@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/labstack/echo/v4"
+)
+
+const EventApplyAction = "event_apply"
+
+// applyEventTo applies the update of an event to another user. The test
+// operations of the invertible patch are dropped since they check the old
+// values of the original entity, not of the target.
+func applyEventTo(c echo.Context) error {
+	eventID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	entityID, err := paramInt64(c, "entity_id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.getEvent(eventID)
+	if err != nil {
+		return writeLookupError(c, err)
+	}
+	target, err := s.getUser(entityID)
+	if err != nil {
+		return writeLookupError(c, err)
+	}
+	if target.Locked {
+		return writeError(c, http.StatusLocked, CodeLocked, errUserLocked.Error())
+	}
+
+	p, err := convertToPatch(e.Update)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	p = withoutTestOps(p)
+
+	serialized, err := json.Marshal(target)
+	if err != nil {
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+	patched, err := applyPatch(serialized, p)
+	if err != nil {
+		return writeError(c, http.StatusConflict, CodeConflict, "event does not apply to this user: "+err.Error())
+	}
+
+	u := &User{}
+	err = json.Unmarshal(patched, u)
+	if err != nil {
+		return writeError(c, http.StatusUnprocessableEntity, CodeInvalidInput, err.Error())
+	}
+	u.ID = target.ID
+	u.Locked = target.Locked
+	u.Deleted = target.Deleted
+	u.Version = target.Version + 1
+	setDerivedFields(u)
+	err = validateUser(u)
+	if err != nil {
+		return writeValidationError(c, err)
+	}
+	err = validateTags(u.Tags)
+	if err != nil {
+		return writeError(c, http.StatusUnprocessableEntity, CodeInvalidInput, err.Error())
+	}
+
+	s.users[u.ID] = u
+	err = s.addEvent(initiator(c), subject(c), u.ID, EventApplyAction, target, u, eventMetadata(c))
+	if err != nil {
+		s.users[u.ID] = target
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, u)
+}
+
+func withoutTestOps(p jsonpatch.Patch) jsonpatch.Patch {
+	filtered := make(jsonpatch.Patch, 0, len(p))
+	for _, op := range p {
+		if op.Kind() != "test" {
+			filtered = append(filtered, op)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestApplyEventTo(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Ann","age":30}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":17,"bag":{"phone":"Poco F3","food":"Big tasty","gun":"Beretta"},"version":1}`), http.StatusOK)
+
+	rec := request(t, e, http.MethodPost, "/events/2/apply-to/2", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Age != 17 || u.Name != "Ann" || u.Version != 2 {
+		t.Fatalf("applied user = %+v", u)
+	}
+
+	// an event removing the name leaves the target invalid
+	s.events = append(s.events, &Event{ID: 10, EntityID: 3, EntityType: UserEntity, Update: []byte(`[{"op":"remove","path":"/name"}]`)})
+	rec = request(t, e, http.MethodPost, "/events/10/apply-to/2", "")
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	if apiErr := decodeBody[APIError](t, rec); apiErr.Fields["name"] == "" {
+		t.Fatalf("error = %+v, want the invalid name reported", apiErr)
+	}
+	if s.users[2].Name != "Ann" {
+		t.Fatalf("invalid result was stored: %+v", s.users[2])
+	}
+
+	// user 2 has no bag whose phone the event could replace
+	s.events = append(s.events, &Event{ID: 11, EntityID: 3, EntityType: UserEntity, Update: []byte(`[{"op":"replace","path":"/bag/phone","value":"Pixel"}]`)})
+	expectStatus(t, request(t, e, http.MethodPost, "/events/11/apply-to/2", ""), http.StatusConflict)
+	if len(s.events) != 5 || s.events[2].Action != EventApplyAction {
+		t.Fatalf("%d events, want none recorded by the failed applies", len(s.events))
+	}
+
+	expectStatus(t, request(t, e, http.MethodPost, "/events/99/apply-to/2", ""), http.StatusNotFound)
+	if apiErr := decodeBody[APIError](t, request(t, e, http.MethodPost, "/events/2/apply-to/99", "")); apiErr.Code != CodeNotFound {
+		t.Fatalf("error = %+v", apiErr)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSkipAudit(t *testing.T) {
+	allowSkipAudit = true
+	defer func() { allowSkipAudit = false }()
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":2,"name":"Ann","age":30}`, HeaderSkipAudit, "true"), http.StatusCreated)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2?skip_audit=true", `{"id":2,"name":"Bea","age":30,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodDelete, "/user/2", "", HeaderSkipAudit, "true"), http.StatusNoContent)
+	if len(s.events) != 0 {
+		t.Fatalf("%d events recorded with the audit skipped", len(s.events))
+	}
+	if u := s.users[2]; u == nil || u.Name != "Bea" || !u.Deleted {
+		t.Fatalf("user 2 = %+v", u)
+	}
+
+	allowSkipAudit = false
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":3,"name":"Cid","age":30}`, HeaderSkipAudit, "true"), http.StatusCreated)
+	if len(s.events) != 1 {
+		t.Fatalf("%d events, want the skip header ignored unless allowed", len(s.events))
+	}
+}
+
+func TestEventMetadata(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`, HeaderReason, "typo", HeaderTicket, "T-7"), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":2,"name":"Ann","age":30}`, HeaderTicket, "T-8"), http.StatusCreated)
+	expectStatus(t, request(t, e, http.MethodDelete, "/user/2", ""), http.StatusNoContent)
+
+	rec := request(t, e, http.MethodGet, "/event/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if ev := decodeBody[Event](t, rec); ev.Metadata["reason"] != "typo" || ev.Metadata["ticket"] != "T-7" || len(ev.Metadata) != 2 {
+		t.Fatalf("metadata = %v", ev.Metadata)
+	}
+	rec = request(t, e, http.MethodGet, "/events", "")
+	expectStatus(t, rec, http.StatusOK)
+	list := decodeBody[EventsList](t, rec)
+	if list.Events[1].Metadata["ticket"] != "T-8" || list.Events[2].Metadata != nil {
+		t.Fatalf("listed metadata = %v, %v", list.Events[1].Metadata, list.Events[2].Metadata)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	HeaderAPIKey = "X-API-Key"
+	apiKeysEnv   = "DT_SERVER_API_KEYS"
+
+	principalContextKey = "principal"
+
+	// defaultPrincipal initiates the events of requests that did not
+	// authenticate, and owns the API keys configured without a principal.
+	defaultPrincipal = "admin"
+)
+
+// protectedPrefixes are the routes exposing the whole store or the process,
+// which need a key for every method.
+var protectedPrefixes = []string{"/admin", "/debug"}
+
+// APIKey is a key accepted by requireAPIKey and the principal it identifies.
+type APIKey struct {
+	Principal string
+	Key       string
+}
+
+// parseAPIKeys parses comma-separated principal:key pairs. A key given
+// without a principal belongs to defaultPrincipal.
+func parseAPIKeys(raw string) ([]APIKey, error) {
+	keys := []APIKey{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		k := APIKey{Principal: defaultPrincipal, Key: entry}
+		if principal, key, ok := strings.Cut(entry, ":"); ok {
+			k = APIKey{Principal: strings.TrimSpace(principal), Key: strings.TrimSpace(key)}
+		}
+		if k.Principal == "" || k.Key == "" {
+			return nil, fmt.Errorf("invalid api key %q, expected principal:key or key", entry)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// requireAPIKey makes the requests of every method but GET, HEAD and OPTIONS,
+// and all requests under protectedPrefixes, carry one of keys, in the
+// X-API-Key header or as a bearer token, and records its principal for
+// initiator.
+func requireAPIKey(keys []APIKey) echo.MiddlewareFunc {
+	return middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+		Skipper: func(c echo.Context) bool {
+			if hasAnyPrefix(c.Request().URL.Path, protectedPrefixes) {
+				return false
+			}
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return true
+			}
+			return false
+		},
+		KeyLookup: "header:" + HeaderAPIKey + ",header:" + echo.HeaderAuthorization + ":Bearer ",
+		Validator: func(key string, c echo.Context) (bool, error) {
+			for _, k := range keys {
+				if subtle.ConstantTimeCompare([]byte(key), []byte(k.Key)) == 1 {
+					c.Set(principalContextKey, k.Principal)
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+		ErrorHandler: func(err error, c echo.Context) error {
+			return writeError(c, http.StatusUnauthorized, CodeUnauthorized, "a valid api key is required")
+		},
+	})
+}
+
+// closeProtected refuses all requests under protectedPrefixes. main uses it
+// instead of requireAPIKey when no keys are configured, so an open server
+// still does not let anyone dump, load or compact the store.
+func closeProtected(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if hasAnyPrefix(c.Request().URL.Path, protectedPrefixes) {
+			return writeError(c, http.StatusForbidden, CodeForbidden, "configure an api key to use this route")
+		}
+		return next(c)
+	}
+}
+
+// initiator returns the principal that authenticated the request, to be
+// recorded as the initiator of the events it causes.
+func initiator(c echo.Context) string {
+	if principal, ok := c.Get(principalContextKey).(string); ok {
+		return principal
+	}
+	return defaultPrincipal
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	keys, err := parseAPIKeys("ops:secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSeededStore()
+	e := newTestServer(s)
+	e.Use(requireAPIKey(keys))
+
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1", ""), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodGet, "/admin/dump", ""), http.StatusUnauthorized)
+	expectStatus(t, request(t, e, http.MethodGet, "/admin/stats", ""), http.StatusUnauthorized)
+	expectStatus(t, request(t, e, http.MethodGet, "/debug/vars", ""), http.StatusUnauthorized)
+	expectStatus(t, request(t, e, http.MethodGet, "/admin/dump", "", HeaderAPIKey, "secret"), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`), http.StatusUnauthorized)
+
+	rec := request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`, echo.HeaderAuthorization, "Bearer secret")
+	expectStatus(t, rec, http.StatusOK)
+	if got := s.events[len(s.events)-1].Initiator; got != "ops" {
+		t.Fatalf("initiator = %q, want ops", got)
+	}
+}
+
+func TestCloseProtected(t *testing.T) {
+	e := newTestServer(newSeededStore())
+	e.Use(closeProtected)
+
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1", ""), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`), http.StatusOK)
+	for _, target := range []string{"/admin/dump", "/admin/stats", "/debug/vars"} {
+		rec := request(t, e, http.MethodGet, target, "")
+		expectStatus(t, rec, http.StatusForbidden)
+		if apiErr := decodeBody[APIError](t, rec); apiErr.Code != CodeForbidden {
+			t.Fatalf("%s: error = %+v", target, apiErr)
+		}
+	}
+	expectStatus(t, request(t, e, http.MethodPost, "/admin/load", `{}`), http.StatusForbidden)
+}
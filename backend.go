@@ -0,0 +1,85 @@
+package main
+
+import "encoding/json"
+
+// Backend durably stores what the in-memory Store holds. The Store stays the
+// working set and writes every change through to its backend, when it has
+// one; without a backend the state lives in memory only.
+type Backend interface {
+	GetUser(id int64) (*User, error)
+	ListUsers() ([]*User, error)
+	SaveUser(u *User) error
+	DeleteUser(id int64) error
+	// AppendEvent stores e, replacing a stored event with the same id.
+	AppendEvent(e *Event) error
+	DeleteEvents(ids ...int64) error
+	ListEvents(filters map[string]string) ([]*Event, error)
+	// ReplaceAll discards everything stored and stores users and events.
+	ReplaceAll(users map[int64]*User, events []*Event) error
+	Close() error
+}
+
+// loadFromBackend replaces the state with the one stored in the backend.
+func (s *Store) loadFromBackend() error {
+	list, err := s.backend.ListUsers()
+	if err != nil {
+		return err
+	}
+	events, err := s.backend.ListEvents(nil)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = make(map[int64]*User, len(list))
+	for _, u := range list {
+		s.users[u.ID] = u
+	}
+	s.events = events
+	s.lastEventID = maxEventID(events)
+	return nil
+}
+
+// storeState records the state of an entity after a change made without an
+// event, e.g. an unaudited one, in the WAL and the backend. A nil state means
+// the entity was deleted.
+func (s *Store) storeState(id int64, state any) error {
+	serialized, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = s.logChange(&walRecord{EntityID: id, State: serialized, AfterEventID: s.lastEventID})
+	if err != nil {
+		return err
+	}
+	return s.saveState(id, state)
+}
+
+// saveState writes the state of an entity after a change to the backend.
+// A nil state means the entity was deleted.
+func (s *Store) saveState(id int64, state any) error {
+	s.bumpVersion(id)
+	if s.backend == nil {
+		return nil
+	}
+	if u, ok := state.(*User); ok && u != nil {
+		return s.backend.SaveUser(u)
+	}
+	return s.backend.DeleteUser(id)
+}
+
+// undoInBackend reverts the latest event of an entity in the backend. It is
+// used when undoing a change that already reached it, so failures are only
+// logged.
+func (s *Store) undoInBackend(eventID, id int64, state any) {
+	if s.backend == nil {
+		return
+	}
+	if err := s.backend.DeleteEvents(eventID); err != nil {
+		logger.Error("undo in backend", "event_id", eventID, "user_id", id, "error", err)
+	}
+	if err := s.saveState(id, state); err != nil {
+		logger.Error("undo in backend", "event_id", eventID, "user_id", id, "error", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const AtomicParam = "atomic"
+
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchOp describes one item of a batch request. check must not mutate any
+// state, apply performs the change and undo reverts it for atomic batches.
+type batchOp struct {
+	check func() error
+	apply func() error
+	undo  func()
+}
+
+// isAtomic reports whether the batch is all-or-nothing, the default unless
+// the request asks for best-effort mode with ?atomic=false.
+func isAtomic(c echo.Context) bool {
+	atomic, err := strconv.ParseBool(c.QueryParam(AtomicParam))
+	return err != nil || atomic
+}
+
+// batchFailed reports whether any item of the batch failed.
+func batchFailed(results []BatchItemResult) bool {
+	for _, r := range results {
+		if r.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// runBatch executes ops and reports the outcome of every item. In best-effort
+// mode each item succeeds or fails on its own. In atomic mode any failure
+// reverts the already applied items and the rest are reported as 424.
+func runBatch(ops []batchOp, atomic bool) []BatchItemResult {
+	results := make([]BatchItemResult, len(ops))
+	for i := range results {
+		results[i] = BatchItemResult{Index: i, Status: http.StatusOK}
+	}
+
+	if atomic {
+		failed := false
+		for i, op := range ops {
+			if err := op.check(); err != nil {
+				results[i].Status = http.StatusUnprocessableEntity
+				results[i].Error = err.Error()
+				failed = true
+			}
+		}
+		if failed {
+			markFailedDependency(results)
+			return results
+		}
+	}
+
+	applied := make([]int, 0, len(ops))
+	for i, op := range ops {
+		err := op.check()
+		if err == nil {
+			err = op.apply()
+		}
+		if err != nil {
+			results[i].Status = http.StatusUnprocessableEntity
+			results[i].Error = err.Error()
+			if atomic {
+				for j := len(applied) - 1; j >= 0; j-- {
+					ops[applied[j]].undo()
+				}
+				markFailedDependency(results)
+				return results
+			}
+			continue
+		}
+		applied = append(applied, i)
+	}
+
+	return results
+}
+
+// eventBatch collects the changes recorded by an atomic batch, which reach
+// the WAL and the stream only once the whole batch has been applied.
+type eventBatch struct {
+	records []*walRecord
+}
+
+// logChange writes rec to the WAL, or holds it back while a batch is open.
+// The caller must hold s.mu.
+func (s *Store) logChange(rec *walRecord) error {
+	if s.batch != nil {
+		s.batch.records = append(s.batch.records, rec)
+		return nil
+	}
+	if s.wal != nil {
+		return s.wal.write(rec)
+	}
+	return nil
+}
+
+// beginBatch makes recordEvent hold events back until commitBatch writes
+// them or abortBatch drops them. The caller must hold s.mu.
+func (s *Store) beginBatch() {
+	s.batch = &eventBatch{}
+}
+
+func (s *Store) abortBatch() {
+	s.batch = nil
+}
+
+// commitBatch writes the events held back since beginBatch to the WAL, all
+// in one record, and publishes them. The caller must hold s.mu.
+func (s *Store) commitBatch() error {
+	b := s.batch
+	s.batch = nil
+	if len(b.records) == 0 {
+		return nil
+	}
+	if s.wal != nil {
+		if err := s.wal.AppendBatch(b.records); err != nil {
+			return err
+		}
+	}
+	for _, rec := range b.records {
+		if rec.Event != nil {
+			s.stream.publish(rec.Event)
+		}
+	}
+	return nil
+}
+
+func markFailedDependency(results []BatchItemResult) {
+	for i := range results {
+		if results[i].Error == "" {
+			results[i].Status = http.StatusFailedDependency
+			results[i].Error = "batch aborted"
+		}
+	}
+}
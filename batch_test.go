@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestAtomicBulkUpdateKeepsAbortedEventsOutOfWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	s := newSeededStore()
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	s.wal = wal
+	events, cancel := s.stream.subscribe()
+	defer cancel()
+	e := newTestServer(s)
+
+	// the second item fails only once the first one has been applied
+	rec := request(t, e, http.MethodPut, "/users/bulk", `[{"id":1,"name":"A","age":20,"version":1},{"id":1,"name":"B","age":20,"version":1}]`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	select {
+	case ev := <-events:
+		t.Fatalf("aborted event %d was published", ev.ID)
+	default:
+	}
+
+	replayed := newSeededStore()
+	n, err := replayed.replayWAL(path)
+	if err != nil || n != 0 || replayed.users[1].Name != "John" {
+		t.Fatalf("replayed %d records (%v), user %+v", n, err, replayed.users[1])
+	}
+
+	rec = request(t, e, http.MethodPut, "/users/bulk", `[{"id":1,"name":"A","age":20,"version":1},{"id":2,"name":"B","age":20,"version":0}]`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	rec = request(t, e, http.MethodPut, "/users/bulk", `[{"id":1,"name":"A","age":20,"version":1}]`)
+	expectStatus(t, rec, http.StatusOK)
+	if ev := <-events; ev.EntityID != 1 {
+		t.Fatalf("published event of user %d", ev.EntityID)
+	}
+
+	replayed = newSeededStore()
+	n, err = replayed.replayWAL(path)
+	if err != nil || n != 1 || replayed.users[1].Name != "A" {
+		t.Fatalf("replayed %d records (%v), user %+v", n, err, replayed.users[1])
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+const patchedCacheSize = 1024
+
+type patchedKey struct {
+	patchType string
+	eventID   int64
+	entityID  int64
+}
+
+// patchedEntry is a user reconstructed by getPatched. It is valid as long as
+// version is the current version of the user.
+type patchedEntry struct {
+	version  uint64
+	user     *User
+	replayed int
+}
+
+func newPatchedCache() *lru.Cache[patchedKey, patchedEntry] {
+	cache, err := lru.New[patchedKey, patchedEntry](patchedCacheSize)
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
+
+// bumpVersion invalidates the cached reconstructions of user id. Every change
+// of its state or events must call it. The caller must hold s.mu.
+func (s *Store) bumpVersion(id int64) {
+	s.versions[id]++
+}
+
+// resetVersions invalidates all cached reconstructions, for changes replacing
+// the whole store. The caller must hold s.mu.
+func (s *Store) resetVersions() {
+	s.versions = make(map[int64]uint64)
+	s.patched.Purge()
+}
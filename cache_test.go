@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGetPatchedCache(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+
+	first, _, err := s.getPatched(RollbackType, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, _, err := s.getPatched(RollbackType, 1, 1)
+	if err != nil || cached != first {
+		t.Fatalf("second reconstruction = %p, %v, want the cached %p", cached, err, first)
+	}
+
+	// a change of the user invalidates its reconstructions
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"B","age":16,"version":2}`), http.StatusOK)
+	after, replayed, err := s.getPatched(RollbackType, 1, 1)
+	if err != nil || after == first || after.Name != "John" || replayed != 2 {
+		t.Fatalf("reconstruction after the change = %+v, %d, %v", after, replayed, err)
+	}
+}
+
+// BenchmarkGetPatched reconstructs a user from the start of a long chain,
+// with and without the cache.
+func BenchmarkGetPatched(b *testing.B) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	for i := int64(1); i <= 200; i++ {
+		rec := request(b, e, http.MethodPut, "/user/update/1", fmt.Sprintf(`{"id":1,"name":"N%d","age":20}`, i))
+		expectStatus(b, rec, http.StatusOK)
+	}
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, _, err := s.getPatched(RollbackType, 1, 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s.mu.Lock()
+			s.bumpVersion(1)
+			s.mu.Unlock()
+			if _, _, err := s.getPatched(RollbackType, 1, 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
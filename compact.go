@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UserSnapshotAction is the action of the snapshot events written by compact.
+// Their patches are empty, so chains replaying patches step over them.
+const UserSnapshotAction = "user_snapshot"
+
+type CompactResult struct {
+	Snapshots int `json:"snapshots"`
+}
+
+func adminCompact(c echo.Context) error {
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.compact()
+	if err != nil {
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &CompactResult{Snapshots: n})
+}
+
+// compact writes, for every user changed since its latest snapshot, a
+// snapshot event holding its current state and marks its older events as
+// superseded by it. Reconstructions then replay from the nearest snapshot
+// instead of from the current state or the first event. It returns the number
+// of snapshots written. The caller must hold s.mu.
+func (s *Store) compact() (int, error) {
+	ids := make([]int64, 0, len(s.users))
+	for id := range s.users {
+		ids = append(ids, id)
+	}
+	sortIDs(ids)
+
+	written := 0
+	for _, id := range ids {
+		if !s.changedSinceSnapshot(id) {
+			continue
+		}
+		u := s.users[id]
+		snapshot, err := json.Marshal(u)
+		if err != nil {
+			return written, err
+		}
+		e, err := s.newEvent("system", "", id, UserSnapshotAction, u, u)
+		if err != nil {
+			return written, err
+		}
+		e.Snapshot = snapshot
+		err = s.recordEvent(e, u)
+		if err != nil {
+			return written, err
+		}
+		written++
+
+		for i, older := range s.events {
+			if older.ID == e.ID || !older.changes(UserEntity, id) || older.SupersededByEventID != nil {
+				continue
+			}
+			err = s.updateEvent(i, func(older *Event) {
+				older.SupersededByEventID = &e.ID
+			})
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// changedSinceSnapshot reports whether user id has events after its latest
+// snapshot, or no snapshot but events.
+func (s *Store) changedSinceSnapshot(id int64) bool {
+	for i := len(s.events) - 1; i >= 0; i-- {
+		e := s.events[i]
+		if e.changes(UserEntity, id) {
+			return e.Action != UserSnapshotAction
+		}
+	}
+	return false
+}
+
+// latestSnapshot returns the latest snapshot event of the given entity with
+// an id up to maxID, or nil.
+func (s *Store) latestSnapshot(entityType string, id, maxID int64) *Event {
+	for i := len(s.events) - 1; i >= 0; i-- {
+		e := s.events[i]
+		if e.ID <= maxID && e.Snapshot != nil && e.changes(entityType, id) {
+			return e
+		}
+	}
+	return nil
+}
+
+// replayFromSnapshot applies to the state held by snap, oldest first, the
+// update patches of the events of its entity after snap up to maxID. It
+// returns the resulting state and the number of events replayed.
+func (s *Store) replayFromSnapshot(snap *Event, maxID int64) ([]byte, int, error) {
+	source := []byte(snap.Snapshot)
+	replayed := 0
+	var err error
+	for _, e := range s.events {
+		if e.ID <= snap.ID || e.ID > maxID || !e.changes(snap.entityType(), snap.EntityID) {
+			continue
+		}
+		source, err = patch(e, UpdateType, source)
+		if err != nil {
+			return nil, 0, err
+		}
+		replayed++
+	}
+	return source, replayed, nil
+}
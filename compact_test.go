@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+
+	rec := request(t, e, http.MethodPost, "/admin/compact", "")
+	expectStatus(t, rec, http.StatusOK)
+	if res := decodeBody[CompactResult](t, rec); res.Snapshots != 1 {
+		t.Fatalf("wrote %d snapshots, want one for user 2 only", res.Snapshots)
+	}
+	snap := s.events[3]
+	if snap.Action != UserSnapshotAction || snap.Snapshot == nil {
+		t.Fatalf("snapshot event = %+v", snap)
+	}
+	for _, older := range s.events[:3] {
+		if older.SupersededByEventID == nil || *older.SupersededByEventID != snap.ID {
+			t.Fatalf("event %d is not superseded by the snapshot", older.ID)
+		}
+	}
+	rec = request(t, e, http.MethodPost, "/admin/compact", "")
+	if res := decodeBody[CompactResult](t, rec); res.Snapshots != 0 {
+		t.Fatalf("compacting again wrote %d snapshots", res.Snapshots)
+	}
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Dee","age":30,"version":3}`), http.StatusOK)
+	// history before and after the snapshot still reconstructs
+	for target, name := range map[string]string{
+		"/patch/rollback/2/2": "Ann",
+		"/patch/rollback/5/2": "Cid",
+		"/user/2/forward/2":   "Bea",
+		"/user/2/forward/4":   "Cid",
+		"/user/2/forward/5":   "Dee",
+		"/user/2/original":    "Ann",
+	} {
+		rec := request(t, e, http.MethodGet, target, "")
+		expectStatus(t, rec, http.StatusOK)
+		if u := decodeBody[User](t, rec); u.Name != name {
+			t.Errorf("%s: user = %+v, want %s", target, u, name)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+const (
+	defaultAddr = ":8080"
+	addrEnv     = "DT_SERVER_ADDR"
+)
+
+// resolveAddr picks the listen address: an explicitly set flag wins over the
+// environment, which wins over the default.
+func resolveAddr(flagValue string, flagSet bool, env string) (string, error) {
+	addr := defaultAddr
+	switch {
+	case flagSet:
+		addr = flagValue
+	case env != "":
+		addr = env
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("invalid listen address %q: bad port", addr)
+	}
+	return addr, nil
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func listenAddr(flagValue string) (string, error) {
+	return resolveAddr(flagValue, isFlagSet("addr"), os.Getenv(addrEnv))
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestResolveAddr(t *testing.T) {
+	tests := []struct {
+		flag    string
+		flagSet bool
+		env     string
+		want    string
+	}{
+		{defaultAddr, false, "", ":8080"},
+		{defaultAddr, false, "127.0.0.1:9000", "127.0.0.1:9000"},
+		{":7000", true, "127.0.0.1:9000", ":7000"},
+		{":0", true, "", ":0"},
+	}
+	for _, tt := range tests {
+		got, err := resolveAddr(tt.flag, tt.flagSet, tt.env)
+		if err != nil || got != tt.want {
+			t.Errorf("resolveAddr(%q, %v, %q) = %q, %v, want %q", tt.flag, tt.flagSet, tt.env, got, err, tt.want)
+		}
+	}
+
+	for _, addr := range []string{"8080", ":http", ":70000", "host:-1"} {
+		if _, err := resolveAddr(addr, true, ""); err == nil {
+			t.Errorf("%q: want an invalid address error", addr)
+		}
+	}
+	if _, err := resolveAddr(defaultAddr, false, "nope"); err == nil {
+		t.Error("invalid env address accepted")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	CursorParam  = "cursor"
+	AfterIDParam = "after_id"
+	LimitParam   = "limit"
+	OffsetParam  = "offset"
+
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursorSecret signs continuation tokens. Unless set with -cursor-secret it is
+// random, so tokens do not survive a restart.
+var cursorSecret = randomSecret()
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+type EventsPage struct {
+	Events []*Event `json:"events"`
+	Next   string   `json:"next,omitempty"`
+}
+
+// encodeCursor returns an opaque token for the position right after the
+// event with the given id.
+func encodeCursor(id int64) string {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, uint64(id))
+	mac := hmac.New(sha256.New, cursorSecret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(payload))
+}
+
+func decodeCursor(token string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != 8+sha256.Size {
+		return 0, errInvalidCursor
+	}
+	payload, sum := raw[:8], raw[8:]
+	mac := hmac.New(sha256.New, cursorSecret)
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return 0, errInvalidCursor
+	}
+	return int64(binary.BigEndian.Uint64(payload)), nil
+}
+
+// parseLimit reads ?limit=, defaulting to defaultPageLimit and clamping it to
+// maxPageLimit.
+func parseLimit(c echo.Context) (int, error) {
+	if c.QueryParam(LimitParam) == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(c.QueryParam(LimitParam))
+	if err != nil || limit <= 0 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return limit, nil
+}
+
+func eventsPages(c echo.Context) error {
+	limit, err := parseLimit(c)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	var afterID int64
+	if token := c.QueryParam(CursorParam); token != "" {
+		afterID, err = decodeCursor(token)
+		if err != nil {
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		}
+	}
+
+	return c.JSON(http.StatusOK, getStore(c).getEventsPage(afterID, limit))
+}
+
+func (s *Store) getEventsPage(afterID int64, limit int) *EventsPage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page := &EventsPage{Events: []*Event{}}
+	for _, e := range s.events {
+		if e.ID <= afterID {
+			continue
+		}
+		if len(page.Events) == limit {
+			page.Next = encodeCursor(page.Events[len(page.Events)-1].ID)
+			break
+		}
+		page.Events = append(page.Events, e)
+	}
+	return page
+}
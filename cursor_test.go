@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestEventsPages(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	for i := int64(1); i <= 5; i++ {
+		expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", fmt.Sprintf(`{"id":1,"name":"N%d","age":20,"version":%d}`, i, i)), http.StatusOK)
+	}
+
+	ids := []int64{}
+	target := "/events/pages?limit=2"
+	pages := 0
+	for {
+		rec := request(t, e, http.MethodGet, target, "")
+		expectStatus(t, rec, http.StatusOK)
+		page := decodeBody[EventsPage](t, rec)
+		pages++
+		for _, ev := range page.Events {
+			ids = append(ids, ev.ID)
+		}
+		if page.Next == "" {
+			break
+		}
+		// events appended meanwhile don't shift the next page
+		if pages == 1 {
+			expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"N6","age":20,"version":6}`), http.StatusOK)
+		}
+		target = "/events/pages?limit=2&cursor=" + url.QueryEscape(page.Next)
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4 5 6]" || pages != 3 {
+		t.Fatalf("paged through %v in %d pages", ids, pages)
+	}
+}
+
+func TestEventsPagesRejectsForgedCursors(t *testing.T) {
+	e := newTestServer(newSeededStore())
+
+	// a cursor of another id under the signature of 3
+	valid, other := encodeCursor(3), encodeCursor(4)
+	forged := other[:11] + valid[11:]
+	for _, cursor := range []string{"x", "AAAA", forged} {
+		rec := request(t, e, http.MethodGet, "/events/pages?cursor="+url.QueryEscape(cursor), "")
+		expectStatus(t, rec, http.StatusBadRequest)
+		if apiErr := decodeBody[APIError](t, rec); apiErr.Message != errInvalidCursor.Error() {
+			t.Errorf("%q: message = %q", cursor, apiErr.Message)
+		}
+	}
+	if id, err := decodeCursor(encodeCursor(42)); err != nil || id != 42 {
+		t.Fatalf("round trip = %d, %v", id, err)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wI2L/jsondiff"
+)
+
+// UsersDiffRequest compares either two user sets or the reconstructed user
+// sets at two points in time.
+type UsersDiffRequest struct {
+	Old  []*User    `json:"old,omitempty"`
+	New  []*User    `json:"new,omitempty"`
+	From *time.Time `json:"from,omitempty"`
+	To   *time.Time `json:"to,omitempty"`
+}
+
+type UserPatch struct {
+	ID    int64          `json:"id"`
+	Patch jsondiff.Patch `json:"patch"`
+}
+
+// MinimalParam asks diffUser for patches without test operations.
+const MinimalParam = "minimal"
+
+// UserDiffRequest holds the two users compared by diffUser.
+type UserDiffRequest struct {
+	Old *User `json:"old"`
+	New *User `json:"new"`
+}
+
+// UserDiff holds the patch turning the old user into the new one and its
+// inverse.
+type UserDiff struct {
+	Update   jsondiff.Patch `json:"update"`
+	Rollback jsondiff.Patch `json:"rollback"`
+}
+
+// diffUser returns the patches an update from the old to the new user in the
+// request would record, without recording anything. With ?minimal=true they
+// are not invertible and hold no test operations.
+func diffUser(c echo.Context) error {
+	req := &UserDiffRequest{}
+	err := c.Bind(req)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	minimal, _ := strconv.ParseBool(c.QueryParam(MinimalParam))
+	rollback, update, err := diffPatches(req.Old, req.New, !minimal)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &UserDiff{Update: update, Rollback: rollback})
+}
+
+func diffUsers(c echo.Context) error {
+	req := &UsersDiffRequest{}
+	err := c.Bind(req)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	oldUsers, newUsers := req.Old, req.New
+	if req.From != nil || req.To != nil {
+		if req.From == nil || req.To == nil {
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, "both from and to are required")
+		}
+		s := getStore(c)
+		oldUsers, err = s.getUsersAt(c.Request().Context(), *req.From)
+		if err != nil {
+			return writeError(c, http.StatusServiceUnavailable, CodeInternal, err.Error())
+		}
+		newUsers, err = s.getUsersAt(c.Request().Context(), *req.To)
+		if err != nil {
+			return writeError(c, http.StatusServiceUnavailable, CodeInternal, err.Error())
+		}
+	}
+
+	oldByID, err := indexUsers(oldUsers)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	newByID, err := indexUsers(newUsers)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	added, removed, common := []int64{}, []int64{}, []int64{}
+	for id := range newByID {
+		if _, ok := oldByID[id]; ok {
+			common = append(common, id)
+		} else {
+			added = append(added, id)
+		}
+	}
+	for id := range oldByID {
+		if _, ok := newByID[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	sortIDs(added)
+	sortIDs(removed)
+	sortIDs(common)
+
+	// the per-user patches are written one by one instead of building the
+	// whole response in memory
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	w.WriteHeader(http.StatusOK)
+	write := func(v any) {
+		b, _ := json.Marshal(v)
+		w.Write(b)
+	}
+
+	w.Write([]byte(`{"added":`))
+	write(added)
+	w.Write([]byte(`,"removed":`))
+	write(removed)
+	w.Write([]byte(`,"changed":[`))
+	first := true
+	for _, id := range common {
+		_, update, err := extractDiffs(oldByID[id], newByID[id])
+		if err != nil {
+			return err
+		}
+		if len(update) == 0 {
+			continue
+		}
+		if !first {
+			w.Write([]byte(","))
+		}
+		first = false
+		write(&UserPatch{ID: id, Patch: update})
+		w.Flush()
+	}
+	w.Write([]byte("]}\n"))
+
+	return nil
+}
+
+func indexUsers(list []*User) (map[int64]*User, error) {
+	byID := make(map[int64]*User, len(list))
+	for _, u := range list {
+		if u == nil {
+			continue
+		}
+		if _, ok := byID[u.ID]; ok {
+			return nil, errDuplicateUserID
+		}
+		byID[u.ID] = u
+	}
+	return byID, nil
+}
+
+func sortIDs(ids []int64) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
+
+// PatchTypeParam selects the patch returned by userEventDiff.
+const PatchTypeParam = "patch_type"
+
+// userEventDiff returns the update patch the event applied to the user, or
+// with ?patch_type=rollback the patch that reverts it.
+func userEventDiff(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	eventID, err := paramInt64(c, "event_id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	patchType := c.QueryParam(PatchTypeParam)
+	if patchType == "" {
+		patchType = UpdateType
+	}
+	if !patchTypes[patchType] {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, errUnknownPatchType.Error())
+	}
+
+	s := getStore(c)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, err := s.getEvent(eventID)
+	if err != nil {
+		return writeLookupError(c, err)
+	}
+	if !e.changes(UserEntity, entityID) {
+		return writeError(c, http.StatusNotFound, CodeNotFound, errEventOfOtherUser.Error())
+	}
+	p, err := getRequiredPatch(e, patchType)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, p)
+}
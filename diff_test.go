@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDiffUser(t *testing.T) {
+	e := newTestServer(NewStore())
+	body := `{"old":{"id":1,"name":"John","bag":{"phone":"Poco F3"}},"new":{"id":1,"name":"John","bag":{"phone":"Pixel"}}}`
+
+	rec := request(t, e, http.MethodPost, "/diff", body)
+	expectStatus(t, rec, http.StatusOK)
+	diff := decodeBody[UserDiff](t, rec)
+	update, _ := json.Marshal(diff.Update)
+	rollback, _ := json.Marshal(diff.Rollback)
+	if string(update) != `[{"op":"test","path":"/bag/phone","value":"Poco F3"},{"op":"replace","path":"/bag/phone","value":"Pixel"}]` {
+		t.Fatalf("update = %s", update)
+	}
+	if string(rollback) != `[{"op":"test","path":"/bag/phone","value":"Pixel"},{"op":"replace","path":"/bag/phone","value":"Poco F3"}]` {
+		t.Fatalf("rollback = %s", rollback)
+	}
+
+	rec = request(t, e, http.MethodPost, "/diff?minimal=true", body)
+	expectStatus(t, rec, http.StatusOK)
+	if update, _ := json.Marshal(decodeBody[UserDiff](t, rec).Update); strings.Contains(string(update), `"test"`) {
+		t.Fatalf("minimal update = %s", update)
+	}
+	expectStatus(t, request(t, e, http.MethodPost, "/diff", `{"old":`), http.StatusBadRequest)
+}
+
+func TestDiffUsers(t *testing.T) {
+	e := newTestServer(NewStore())
+
+	rec := request(t, e, http.MethodPost, "/users/diff", `{"old":[{"id":1,"name":"A"},{"id":2,"name":"B"}],"new":[{"id":3,"name":"C"},{"id":1,"name":"Z"}]}`)
+	expectStatus(t, rec, http.StatusOK)
+	diff := decodeBody[struct {
+		Added   []int64     `json:"added"`
+		Removed []int64     `json:"removed"`
+		Changed []UserPatch `json:"changed"`
+	}](t, rec)
+	if len(diff.Added) != 1 || diff.Added[0] != 3 || len(diff.Removed) != 1 || diff.Removed[0] != 2 {
+		t.Fatalf("diff = %+v", diff)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].ID != 1 || len(diff.Changed[0].Patch) == 0 {
+		t.Fatalf("changed = %+v", diff.Changed)
+	}
+
+	expectStatus(t, request(t, e, http.MethodPost, "/users/diff", `{"old":[{"id":1},{"id":1}]}`), http.StatusBadRequest)
+	expectStatus(t, request(t, e, http.MethodPost, "/users/diff", `{"from":"2024-01-01T00:00:00Z"}`), http.StatusBadRequest)
+}
+
+func TestUserEventDiff(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Ann","age":30}`), http.StatusOK)
+
+	for query, want := range map[string]json.RawMessage{"": s.events[0].Update, "?patch_type=update": s.events[0].Update, "?patch_type=rollback": s.events[0].Rollback} {
+		rec := request(t, e, http.MethodGet, "/user/1/diff/1"+query, "")
+		expectStatus(t, rec, http.StatusOK)
+		if got := strings.TrimSpace(rec.Body.String()); got != string(want) {
+			t.Errorf("%q: patch = %s, want %s", query, got, want)
+		}
+	}
+
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1/diff/2", ""), http.StatusNotFound)
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1/diff/3", ""), http.StatusNotFound)
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1/diff/1?patch_type=bogus", ""), http.StatusBadRequest)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// UserEntity is the entity type of users. Events recorded before events had
+// an entity type are user events.
+const UserEntity = "user"
+
+// entityFactories maps the entity types the event log can reconstruct to
+// functions returning a fresh value to decode an entity of that type into.
+var entityFactories = map[string]func() any{
+	UserEntity: func() any { return &User{} },
+}
+
+// registerEntity makes entities of the given type reconstructable from their
+// events.
+func registerEntity(entityType string, factory func() any) {
+	entityFactories[entityType] = factory
+}
+
+func newEntity(entityType string) (any, error) {
+	factory, ok := entityFactories[entityType]
+	if !ok {
+		return nil, fmt.Errorf("unknown entity type %q", entityType)
+	}
+	return factory(), nil
+}
+
+// entityType returns the type of the entity e changed.
+func (e *Event) entityType() string {
+	if e.EntityType == "" {
+		return UserEntity
+	}
+	return e.EntityType
+}
+
+// changes reports whether e changed the entity of the given type and id.
+func (e *Event) changes(entityType string, id int64) bool {
+	return e.EntityID == id && e.entityType() == entityType
+}
+
+// reconstructEntity applies to current, the serialized current state of an
+// entity, the patchType patches of its events from eventID on, newest first,
+// and decodes the result into a value of entityType. It returns the number of
+// events replayed.
+func (s *Store) reconstructEntity(entityType, patchType string, eventID, entityID int64, current []byte) (any, int, error) {
+	entity, err := newEntity(entityType)
+	if err != nil {
+		return nil, 0, err
+	}
+	chain, err := s.getEvents(eventID)
+	if err != nil {
+		return nil, 0, err
+	}
+	requiredEvents := make([]*Event, 0, len(chain))
+	for _, e := range chain {
+		if e.changes(entityType, entityID) {
+			requiredEvents = append(requiredEvents, e)
+		}
+	}
+
+	if patchType == RollbackType {
+		// the state before eventID may be closer to a snapshot than to the
+		// current state
+		if snap := s.latestSnapshot(entityType, entityID, eventID-1); snap != nil {
+			source, replayed, err := s.replayFromSnapshot(snap, eventID-1)
+			if err != nil {
+				return nil, 0, err
+			}
+			if replayed < len(requiredEvents) {
+				err = json.Unmarshal(source, entity)
+				if err != nil {
+					return nil, 0, err
+				}
+				return entity, replayed, nil
+			}
+		}
+	}
+
+	// with no events of the entity in the chain, or only empty patches, the
+	// current state is returned unchanged
+	source := current
+	for i := len(requiredEvents) - 1; i >= 0; i-- {
+		source, err = patch(requiredEvents[i], patchType, source)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	err = json.Unmarshal(source, entity)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entity, len(requiredEvents), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestReconstructIgnoresOtherEntityTypes(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`), http.StatusOK)
+	// an event of an entity sharing the id of user 1
+	s.events = append(s.events, &Event{
+		ID:         2,
+		EntityID:   1,
+		EntityType: "product",
+		Rollback:   []byte(`[{"op":"replace","path":"/name","value":"Chair"}]`),
+		Update:     []byte(`[{"op":"replace","path":"/name","value":"Table"}]`),
+	})
+
+	rec := request(t, e, http.MethodGet, "/patch/rollback/1/1?meta=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	resp := decodeBody[PatchedResponse](t, rec)
+	if resp.EventsReplayed != 1 || resp.User.Name != "John" {
+		t.Fatalf("reconstructed %+v from %d events", resp.User, resp.EventsReplayed)
+	}
+}
+
+type product struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Price int    `json:"price"`
+}
+
+func TestReconstructRegisteredEntity(t *testing.T) {
+	registerEntity("product", func() any { return &product{} })
+	defer delete(entityFactories, "product")
+
+	s := NewStore()
+	states := []*product{{1, "Chair", 10}, {1, "Chair", 12}, {1, "Stool", 12}}
+	for i := 1; i < len(states); i++ {
+		rollback, update, err := extractRawDiffs(states[i-1], states[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.events = append(s.events, &Event{
+			ID:         int64(i),
+			EntityID:   1,
+			EntityType: "product",
+			Action:     "product_update",
+			Rollback:   rollback,
+			Update:     update,
+		})
+	}
+	current, err := json.Marshal(states[len(states)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for eventID, want := range map[int64]*product{1: states[0], 2: states[1]} {
+		entity, replayed, err := s.reconstructEntity("product", RollbackType, eventID, 1, current)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, ok := entity.(*product)
+		if !ok || *p != *want || replayed != 3-int(eventID) {
+			t.Fatalf("rolled back to event %d: %#v from %d events, want %+v", eventID, entity, replayed, want)
+		}
+	}
+	if _, _, err := s.reconstructEntity("order", RollbackType, 1, 1, current); err == nil {
+		t.Fatal("reconstructed an unregistered entity type")
+	}
+}
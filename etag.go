@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderETag        = "ETag"
+	HeaderIfNoneMatch = "If-None-Match"
+)
+
+// weakETag hashes the serialized body together with scope, so identical
+// bodies served for different queries still get different tags.
+func weakETag(body []byte, scope string) string {
+	h := sha256.New()
+	h.Write([]byte(scope))
+	h.Write([]byte{0})
+	h.Write(body)
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonWithETag writes v as JSON, or as YAML when the request accepts it, with
+// a weak ETag, answering 304 when the request's If-None-Match already carries
+// it.
+func jsonWithETag(c echo.Context, v any, scope string) error {
+	body, contentType, err := marshalBody(c, v)
+	if err != nil {
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+
+	etag := weakETag(body, scope)
+	c.Response().Header().Set(HeaderETag, etag)
+	if inm := c.Request().Header.Get(HeaderIfNoneMatch); inm != "" && etagMatches(inm, etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return c.Blob(http.StatusOK, contentType, body)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestUserETag(t *testing.T) {
+	e := newTestServer(newSeededStore())
+
+	rec := request(t, e, http.MethodGet, "/user/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	etag := rec.Header().Get(HeaderETag)
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	rec = request(t, e, http.MethodGet, "/user/1", "", HeaderIfNoneMatch, etag)
+	expectStatus(t, rec, http.StatusNotModified)
+	if rec.Body.Len() != 0 {
+		t.Fatalf("304 with body %s", rec.Body.String())
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1", "", HeaderIfNoneMatch, `"other", `+etag[2:]), http.StatusNotModified)
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1", "", HeaderIfNoneMatch, "*"), http.StatusNotModified)
+
+	// other fields are another representation
+	rec = request(t, e, http.MethodGet, "/user/1?fields=name", "", HeaderIfNoneMatch, etag)
+	expectStatus(t, rec, http.StatusOK)
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+	rec = request(t, e, http.MethodGet, "/user/1", "", HeaderIfNoneMatch, etag)
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Header().Get(HeaderETag) == etag {
+		t.Fatal("ETag did not change with the user")
+	}
+}
+
+func TestEventsListETag(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+	list := "/events?created_at=2023-01-01T00:00:00Z"
+
+	rec := request(t, e, http.MethodGet, list, "")
+	expectStatus(t, rec, http.StatusOK)
+	etag := rec.Header().Get(HeaderETag)
+	expectStatus(t, request(t, e, http.MethodGet, list, "", HeaderIfNoneMatch, etag), http.StatusNotModified)
+	expectStatus(t, request(t, e, http.MethodGet, list, "", HeaderIfNoneMatch, `"other", `+etag[2:]), http.StatusNotModified)
+
+	// the same events for another query are tagged apart
+	rec = request(t, e, http.MethodGet, list+"&exclude_reverted=false", "", HeaderIfNoneMatch, etag)
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Header().Get(HeaderETag) == etag {
+		t.Fatal("ETag does not depend on the query")
+	}
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"B","age":16,"version":2}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodGet, list, "", HeaderIfNoneMatch, etag), http.StatusOK)
+}
+
+func TestETagMarshalError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	if err := jsonWithETag(c, make(chan int), ""); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, rec, http.StatusInternalServerError)
+	if apiErr := decodeBody[APIError](t, rec); apiErr.Code != CodeInternal {
+		t.Fatalf("error = %+v", apiErr)
+	}
+	if rec.Header().Get(HeaderETag) != "" {
+		t.Fatal("ETag set on an error")
+	}
+}
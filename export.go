@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const MIMEApplicationNDJSON = "application/x-ndjson"
+
+// exportEvents writes the events matching the /events filters as
+// newline-delimited JSON, one event per line, flushing as it goes.
+func exportEvents(c echo.Context) error {
+	events, err := getStore(c).getEventsList(eventFilters(c))
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, MIMEApplicationNDJSON)
+	w.WriteHeader(http.StatusOK)
+	for _, e := range events {
+		line, err := marshalJSON(c, e)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(line, '\n'))
+		if err != nil {
+			return err
+		}
+		w.Flush()
+	}
+
+	return nil
+}
+
+type ImportResult struct {
+	Imported int `json:"imported"`
+}
+
+// importEvents appends the newline-delimited events of the request body, as
+// written by exportEvents, to the log. Each event must apply to the state its
+// user has after the preceding ones, which it then advances; if any line does
+// not, nothing is imported.
+func importEvents(c echo.Context) error {
+	events, lines := []*Event{}, []int{}
+	scanner := bufio.NewScanner(c.Request().Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		e := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("line %d: %v", line, err))
+		}
+		if err := validateImportedEvent(e); err != nil {
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("line %d: %v", line, err))
+		}
+		events = append(events, e)
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.importEvents(events, lines)
+	if err != nil {
+		return writeError(c, http.StatusUnprocessableEntity, CodeInvalidInput, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &ImportResult{Imported: n})
+}
+
+func validateImportedEvent(e *Event) error {
+	if e.EntityID <= 0 {
+		return errors.New("entity_id must be a positive integer")
+	}
+	if e.entityType() != UserEntity {
+		return fmt.Errorf("events of %s entities can't be imported", e.entityType())
+	}
+	if e.Action == "" {
+		return errors.New("action is required")
+	}
+	if e.CreatedAt.IsZero() {
+		return errors.New("created_at is required")
+	}
+	return normalizePatches(e)
+}
+
+// importEvents appends events, read from the given lines, keeping their IDs
+// when they are above the ones in the log and reassigning them, references
+// included, otherwise. It first checks that every event applies, so on error
+// nothing is changed. The caller must hold s.mu.
+func (s *Store) importEvents(events []*Event, lines []int) (int, error) {
+	states := make(map[int64][]byte)
+	after := make([]*User, len(events))
+	for i, e := range events {
+		source, ok := states[e.EntityID]
+		if !ok {
+			var err error
+			source, err = s.currentState(e.EntityID)
+			if errors.Is(err, errUserNotFound) {
+				source, err = []byte("null"), nil
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+		next, err := patch(e, UpdateType, source)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: event does not apply to user %d: %w", lines[i], e.EntityID, err)
+		}
+		states[e.EntityID] = next
+		if string(next) != "null" {
+			after[i] = &User{}
+			if err := json.Unmarshal(next, after[i]); err != nil {
+				return 0, fmt.Errorf("line %d: %w", lines[i], err)
+			}
+		}
+	}
+
+	renamed := make(map[int64]int64)
+	next := s.nextEventID()
+	for _, e := range events {
+		if e.ID < next {
+			renamed[e.ID] = next
+			e.ID = next
+		}
+		next = e.ID + 1
+	}
+	for _, e := range events {
+		for _, ref := range []**int64{&e.RevertedByEventID, &e.SupersededByEventID} {
+			if *ref == nil {
+				continue
+			}
+			if id, ok := renamed[**ref]; ok {
+				*ref = &id
+			}
+		}
+	}
+
+	for i, e := range events {
+		var err error
+		if after[i] == nil {
+			delete(s.users, e.EntityID)
+			err = s.recordEvent(e, nil)
+		} else {
+			s.users[e.EntityID] = after[i]
+			err = s.recordEvent(e, after[i])
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(events), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportEvents(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+
+	rec := request(t, e, http.MethodGet, "/events/export?action=user_update", "")
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get("Content-Type"); ct != MIMEApplicationNDJSON {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	scanner := bufio.NewScanner(rec.Body)
+	ids := []int64{}
+	for scanner.Scan() {
+		ev := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), ev); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		ids = append(ids, ev.ID)
+	}
+	if len(ids) != 2 || ids[0] != 2 || ids[1] != 3 {
+		t.Fatalf("exported events %v", ids)
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/events/export?created_at=never", ""), http.StatusBadRequest)
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"Jo","age":16,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/events/3/rollback", ""), http.StatusOK)
+	exported := request(t, e, http.MethodGet, "/events/export?time=rfc3339nano", "").Body.String()
+
+	imported := newSeededStore()
+	ie := newTestServer(imported)
+	rec := request(t, ie, http.MethodPost, "/events/import", exported)
+	expectStatus(t, rec, http.StatusOK)
+	if res := decodeBody[ImportResult](t, rec); res.Imported != 5 {
+		t.Fatalf("imported %d events", res.Imported)
+	}
+	if !reflect.DeepEqual(imported.users, s.users) {
+		t.Fatalf("imported users %+v, want %+v", imported.users, s.users)
+	}
+	if !reflect.DeepEqual(imported.events, s.events) {
+		t.Fatal("imported events differ from the exported ones")
+	}
+}
+
+func TestImportReassignsIDs(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+	expectStatus(t, request(t, e, http.MethodPost, "/events/3/rollback", ""), http.StatusOK)
+	exported := request(t, e, http.MethodGet, "/events/export?initiator=admin&time=rfc3339nano", "").Body.String()
+
+	target := newSeededStore()
+	te := newTestServer(target)
+	expectStatus(t, request(t, te, http.MethodPut, "/user/update/1", `{"id":1,"name":"Jo","age":16,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, te, http.MethodPost, "/events/import", exported), http.StatusOK)
+
+	ids := []int64{}
+	for _, ev := range target.events {
+		ids = append(ids, ev.ID)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3, 4, 5}) {
+		t.Fatalf("event ids %v", ids)
+	}
+	// the reference to the renamed rollback event follows it
+	if reverted := target.events[3].RevertedByEventID; reverted == nil || *reverted != 5 {
+		t.Fatalf("imported event 4 is reverted by %v, want 5", reverted)
+	}
+}
+
+func TestImportRejectsInvalidLines(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	valid := `{"id":1,"created_at":"2024-01-01T00:00:00Z","entity_id":1,"action":"user_update","update":[{"op":"test","path":"/name","value":"John"},{"op":"replace","path":"/name","value":"A"}],"rollback":[]}`
+
+	tests := []struct {
+		body   string
+		status int
+		msg    string
+	}{
+		{valid + "\n{\"id\":", http.StatusBadRequest, "line 2:"},
+		{valid + "\n\n" + `{"created_at":"2024-01-01T00:00:00Z","action":"x"}`, http.StatusBadRequest, "line 3: entity_id"},
+		{`{"entity_id":1,"action":"x"}`, http.StatusBadRequest, "line 1: created_at"},
+		{`{"entity_id":1,"entity_type":"order","action":"x","created_at":"2024-01-01T00:00:00Z"}`, http.StatusBadRequest, "line 1: events of order"},
+		{valid + "\n" + valid, http.StatusUnprocessableEntity, "line 2: event does not apply to user 1"},
+	}
+	for _, tt := range tests {
+		rec := request(t, e, http.MethodPost, "/events/import", tt.body)
+		expectStatus(t, rec, tt.status)
+		if apiErr := decodeBody[APIError](t, rec); !strings.HasPrefix(apiErr.Message, tt.msg) {
+			t.Errorf("message = %q, want %s", apiErr.Message, tt.msg)
+		}
+	}
+	if len(s.events) != 0 || s.users[1].Name != "John" {
+		t.Fatal("a rejected import changed the store")
+	}
+
+	rec := request(t, e, http.MethodPost, "/events/import", valid+"\n")
+	expectStatus(t, rec, http.StatusOK)
+	if s.users[1].Name != "A" || !bytes.Contains(rec.Body.Bytes(), []byte(`"imported":1`)) {
+		t.Fatalf("user after the import = %+v", s.users[1])
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	PathParam = "path"
+	ToParam   = "to"
+	FromParam = "from"
+)
+
+type patchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// valueChange is what an update did to a single path. Invertible patches
+// precede replace and remove operations with a test of the old value.
+type valueChange struct {
+	old, new       any
+	hasOld, hasNew bool
+}
+
+func findEvents(c echo.Context) error {
+	path := c.QueryParam(PathParam)
+	if path == "" {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, "path is required")
+	}
+	if err := validatePointer(path); err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	var to, from *any
+	if c.QueryParams().Has(ToParam) {
+		v := parseQueryValue(c.QueryParam(ToParam))
+		to = &v
+	}
+	if c.QueryParams().Has(FromParam) {
+		v := parseQueryValue(c.QueryParam(FromParam))
+		from = &v
+	}
+
+	found, err := getStore(c).findEvents(path, from, to)
+	if err != nil {
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, found)
+}
+
+// parseQueryValue reads v as JSON, falling back to a plain string, so both
+// to=30 and to=iPhone 15 work.
+func parseQueryValue(v string) any {
+	var decoded any
+	if err := json.Unmarshal([]byte(v), &decoded); err != nil {
+		return v
+	}
+	return decoded
+}
+
+// findEvents returns, newest first, the events whose update changed path
+// from the value from to the value to. A nil bound matches anything.
+func (s *Store) findEvents(path string, from, to *any) ([]*Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := []*Event{}
+	for i := len(s.events) - 1; i >= 0; i-- {
+		e := s.events[i]
+		change, err := pathChange(e.Update, path)
+		if err != nil {
+			return nil, err
+		}
+		if change == nil {
+			continue
+		}
+		if to != nil && (!change.hasNew || !reflect.DeepEqual(change.new, *to)) {
+			continue
+		}
+		if from != nil && (!change.hasOld || !reflect.DeepEqual(change.old, *from)) {
+			continue
+		}
+		found = append(found, e)
+	}
+	return found, nil
+}
+
+// pathChange returns what the update patch did to path, nil if it left it
+// as it was.
+func pathChange(update any, path string) (*valueChange, error) {
+	serialized, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	ops := []patchOp{}
+	if err := json.Unmarshal(serialized, &ops); err != nil {
+		return nil, err
+	}
+
+	var change *valueChange
+	for _, op := range ops {
+		// operations on a container of path, like adding the whole bag or
+		// creating the user, change path too
+		rest, ok := strings.CutPrefix(path, op.Path)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			continue
+		}
+		if change == nil {
+			change = &valueChange{}
+		}
+		value, found := resolvePointer(op.Value, rest)
+		switch op.Op {
+		case "test":
+			change.old, change.hasOld = value, found
+		case "add", "replace":
+			change.new, change.hasNew = value, found
+		case "remove":
+			change.new, change.hasNew = nil, false
+		}
+	}
+	if change == nil || (!change.hasOld && !change.hasNew) {
+		return nil, nil
+	}
+	if change.hasOld && change.hasNew && reflect.DeepEqual(change.old, change.new) {
+		return nil, nil
+	}
+	return change, nil
+}
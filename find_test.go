@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestFindEvents(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	for i, age := range []int{20, 30, 20} {
+		expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", fmt.Sprintf(`{"id":1,"name":"John","age":%d,"version":%d}`, age, i+1)), http.StatusOK)
+	}
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"path=/age&to=20", []int64{3, 1}},
+		{"path=/age&from=20", []int64{2}},
+		{"path=/age&from=16&to=20", []int64{1}},
+		{"path=/age", []int64{3, 2, 1}},
+		{"path=/name", nil},
+	}
+	for _, tt := range tests {
+		rec := request(t, e, http.MethodGet, "/events/find?"+tt.query, "")
+		expectStatus(t, rec, http.StatusOK)
+		ids := []int64{}
+		for _, ev := range decodeBody[[]*Event](t, rec) {
+			ids = append(ids, ev.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(append([]int64{}, tt.want...)) {
+			t.Errorf("%s: found %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/events/find", ""), http.StatusBadRequest)
+}
+
+func TestFindEventsInContainers(t *testing.T) {
+	s := NewStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":1,"name":"John","age":16,"bag":{"phone":"Poco F3"}}`), http.StatusCreated)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":16,"bag":{"phone":"iPhone 15"},"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":16,"version":2}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":16,"bag":{"phone":"Poco F3","gun":"Beretta"},"version":3}`), http.StatusOK)
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"path=/bag/phone&to=Poco F3", []int64{4, 1}},
+		{"path=/bag/phone&from=Poco F3", []int64{2}},
+		{"path=/bag/phone&from=iPhone 15", []int64{3}},
+		{"path=/bag/phone", []int64{4, 3, 2, 1}},
+		{"path=/bag/gun", []int64{4}},
+		{"path=/name", []int64{1}},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		rec := request(t, e, http.MethodGet, "/events/find?"+q.Encode(), "")
+		expectStatus(t, rec, http.StatusOK)
+		found := decodeBody[[]*Event](t, rec)
+		ids := make([]int64, len(found))
+		for i, ev := range found {
+			ids[i] = ev.ID
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("%s: found %v, want %v", tt.query, ids, tt.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("%s: found %v, want %v", tt.query, ids, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	DeepParam = "deep"
+
+	healthSampleSize = 10
+	healthDeepBudget = 2 * time.Second
+
+	statusOK       = "ok"
+	statusDegraded = "degraded"
+	checkPass      = "pass"
+	checkFail      = "fail"
+)
+
+type HealthCheck struct {
+	Status  string           `json:"status"`
+	Checked int              `json:"checked,omitempty"`
+	Failed  map[int64]string `json:"failed,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string                  `json:"status"`
+	Checks map[string]*HealthCheck `json:"checks"`
+}
+
+// healthz always reports liveness. With ?deep=true it also verifies the event
+// chains of a sample of users within healthDeepBudget and answers 503 when
+// any of them fails.
+func healthz(c echo.Context) error {
+	resp := &HealthResponse{
+		Status: statusOK,
+		Checks: map[string]*HealthCheck{"liveness": {Status: checkPass}},
+	}
+
+	if deep, _ := strconv.ParseBool(c.QueryParam(DeepParam)); deep {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), healthDeepBudget)
+		defer cancel()
+
+		check := getStore(c).spotCheckIntegrity(ctx, healthSampleSize)
+		resp.Checks["event_chain"] = check
+		if check.Status != checkPass {
+			resp.Status = statusDegraded
+		}
+	}
+
+	if resp.Status != statusOK {
+		return c.JSON(http.StatusServiceUnavailable, resp)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// spotCheckIntegrity verifies up to n users, relying on map iteration order
+// to pick a different sample each time. Users left unchecked when ctx expires
+// are not counted as failures.
+func (s *Store) spotCheckIntegrity(ctx context.Context, n int) *HealthCheck {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	check := &HealthCheck{Status: checkPass}
+	for id, u := range s.users {
+		if check.Checked == n || ctx.Err() != nil {
+			break
+		}
+		check.Checked++
+		if err := s.verifyUser(u); err != nil {
+			if check.Failed == nil {
+				check.Failed = make(map[int64]string)
+			}
+			check.Failed[id] = err.Error()
+			check.Status = checkFail
+		}
+	}
+	return check
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+
+	rec := request(t, e, http.MethodGet, "/healthz", "")
+	expectStatus(t, rec, http.StatusOK)
+	if resp := decodeBody[HealthResponse](t, rec); resp.Status != statusOK || resp.Checks["event_chain"] != nil {
+		t.Fatalf("shallow health = %+v", resp)
+	}
+	rec = request(t, e, http.MethodGet, "/healthz?deep=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	if check := decodeBody[HealthResponse](t, rec).Checks["event_chain"]; check == nil || check.Status != checkPass || check.Checked != len(s.users) {
+		t.Fatalf("event chain check = %+v", check)
+	}
+
+	s.users[1].Name = "B"
+	rec = request(t, e, http.MethodGet, "/healthz?deep=true", "")
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	resp := decodeBody[HealthResponse](t, rec)
+	if check := resp.Checks["event_chain"]; resp.Status != statusDegraded || check.Status != checkFail || check.Failed[1] == "" {
+		t.Fatalf("health of a drifted store = %+v, %+v", resp, check)
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/healthz", ""), http.StatusOK)
+}
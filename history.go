@@ -0,0 +1,635 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	WhenParam = "when"
+	KeepParam = "keep"
+
+	maxConcurrentReconstructions = 4
+)
+
+// reconstructLimiter bounds the number of reconstructions running at once.
+var reconstructLimiter = make(chan struct{}, maxConcurrentReconstructions)
+
+func acquireReconstruct(ctx context.Context) error {
+	select {
+	case reconstructLimiter <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func releaseReconstruct() {
+	<-reconstructLimiter
+}
+
+func usersAt(c echo.Context) error {
+	when, err := time.Parse(time.RFC3339, c.QueryParam(WhenParam))
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	states, err := getStore(c).getUsersAt(c.Request().Context(), when)
+	if err != nil {
+		return writeError(c, http.StatusServiceUnavailable, CodeInternal, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, states)
+}
+
+func (s *Store) getUsersAt(ctx context.Context, when time.Time) ([]*User, error) {
+	// reconstruct from a copy taken under s.mu, so waiting for the limiter
+	// never holds s.mu: a queued writer would block the readers holding the
+	// tokens
+	s.mu.RLock()
+	ids := make([]int64, 0, len(s.users))
+	for id := range s.users {
+		ids = append(ids, id)
+	}
+	sortIDs(ids)
+	users := make([]*User, len(ids))
+	for i, id := range ids {
+		users[i] = s.users[id]
+	}
+	events := make([]*Event, len(s.events))
+	copy(events, s.events)
+	s.mu.RUnlock()
+
+	reconstructed := make([]*User, len(users))
+	errs := make([]error, len(users))
+	wg := sync.WaitGroup{}
+	for i, u := range users {
+		if err := acquireReconstruct(ctx); err != nil {
+			wg.Wait()
+			return nil, err
+		}
+		wg.Add(1)
+		go func(i int, u *User) {
+			defer wg.Done()
+			defer releaseReconstruct()
+			reconstructed[i], errs[i] = reconstructAt(events, u, when)
+		}(i, u)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// users created after when did not exist yet
+	states := make([]*User, 0, len(reconstructed))
+	for _, u := range reconstructed {
+		if u != nil {
+			states = append(states, u)
+		}
+	}
+	return states, nil
+}
+
+// reconstructAt rolls u back through every event of its chain in events
+// created after when, newest first. It returns nil if u did not exist at that
+// time.
+func reconstructAt(events []*Event, u *User, when time.Time) (*User, error) {
+	return rollbackUser(events, u, func(e *Event) bool {
+		return e.CreatedAt.After(when)
+	})
+}
+
+// rollbackEvents applies, newest first, the rollback patches of the events
+// of u's chain selected by include. It returns nil if they roll back to
+// before the creation of u.
+func (s *Store) rollbackEvents(u *User, include func(e *Event) bool) (*User, error) {
+	return rollbackUser(s.events, u, include)
+}
+
+// rollbackUser does the work of rollbackEvents on the given events.
+func rollbackUser(events []*Event, u *User, include func(e *Event) bool) (*User, error) {
+	source, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	source, err = rollbackChain(events, u.ID, source, include)
+	if err != nil {
+		return nil, err
+	}
+	if string(source) == "null" {
+		return nil, nil
+	}
+
+	state := &User{}
+	err = json.Unmarshal(source, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// rollbackState applies to the serialized state of entity id, newest first,
+// the rollback patches of the events of its chain selected by include.
+func (s *Store) rollbackState(id int64, source []byte, include func(e *Event) bool) ([]byte, error) {
+	return rollbackChain(s.events, id, source, include)
+}
+
+// rollbackChain does the work of rollbackState on the given events.
+func rollbackChain(events []*Event, id int64, source []byte, include func(e *Event) bool) ([]byte, error) {
+	var err error
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		if !e.changes(UserEntity, id) || !include(e) {
+			continue
+		}
+		source, err = patch(e, RollbackType, source)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return source, nil
+}
+
+// userAt reconstructs a user as it was at the ?created_at time.
+func userAt(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	when, err := parseFlexibleDate(c.QueryParam(CreatedAtParam))
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	err = acquireReconstruct(c.Request().Context())
+	if err != nil {
+		return writeError(c, http.StatusServiceUnavailable, CodeInternal, err.Error())
+	}
+	defer releaseReconstruct()
+	u, err := getStore(c).getUserAt(entityID, when)
+	if err != nil {
+		return writeLookupError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, u)
+}
+
+// getUserAt rolls the current state of user id, which may be deleted by now,
+// back through its events created after when. Users that did not exist at
+// that time are reported as not found.
+func (s *Store) getUserAt(id int64, when time.Time) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	source, err := s.currentState(id)
+	if err != nil {
+		return nil, err
+	}
+	source, err = s.rollbackState(id, source, func(e *Event) bool {
+		return e.CreatedAt.After(when)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if string(source) == "null" {
+		return nil, errUserNotFound
+	}
+
+	u := &User{}
+	err = json.Unmarshal(source, u)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func getOriginalUser(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	original, err := getStore(c).getOriginal(entityID)
+	if err != nil {
+		if errors.Is(err, errNoOriginal) {
+			return writeError(c, http.StatusNotFound, CodeNotFound, err.Error())
+		}
+		return writeLookupError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, original)
+}
+
+var errNoOriginal = errors.New("user has no creation baseline")
+
+// getOriginal returns the state a user had right after its creation event,
+// or the seed baseline for users that were never created through the API.
+// Users whose events all roll back to before they existed, like the ones
+// created by an update, have neither.
+func (s *Store) getOriginal(id int64) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, err := s.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var created *Event
+	for _, e := range s.events {
+		if e.changes(UserEntity, id) && e.Action == UserCreateAction {
+			created = e
+			break
+		}
+	}
+
+	original, err := s.rollbackEvents(u, func(e *Event) bool {
+		return created == nil || e.ID > created.ID
+	})
+	if err != nil {
+		return nil, err
+	}
+	if original == nil {
+		return nil, errNoOriginal
+	}
+	return original, nil
+}
+
+func truncateUserHistory(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	keep, err := strconv.Atoi(c.QueryParam(KeepParam))
+	if err != nil || keep < 0 {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, "keep must be a non-negative integer")
+	}
+
+	collapsed, err := getStore(c).truncateHistory(entityID, keep)
+	if err != nil {
+		return writeLookupError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"collapsed": collapsed})
+}
+
+// truncateHistory replaces all but the latest keep events of the user with a
+// single baseline event leading from the oldest known state to the state
+// right before the kept events. The baseline takes the place, ID and time of
+// the newest collapsed event, so rollbacks to the beginning still work.
+func (s *Store) truncateHistory(id int64, keep int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.getUser(id)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.collapseEvents(u, keep)
+}
+
+// collapseEvents does the work of truncateHistory for user u. The caller
+// must hold s.mu.
+func (s *Store) collapseEvents(u *User, keep int) (int, error) {
+	id := u.ID
+	idx := []int{}
+	for i, e := range s.events {
+		if e.changes(UserEntity, id) {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) <= keep+1 {
+		return 0, nil
+	}
+
+	collapse := idx[:len(idx)-keep]
+	last := s.events[collapse[len(collapse)-1]]
+
+	// raw states keep a user created by a collapsed event null at the start
+	current, err := json.Marshal(u)
+	if err != nil {
+		return 0, err
+	}
+	pivot, err := s.rollbackState(id, current, func(e *Event) bool {
+		return e.ID > last.ID
+	})
+	if err != nil {
+		return 0, err
+	}
+	original, err := s.rollbackState(id, current, func(e *Event) bool {
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	rollback, update, err := extractRawDiffs(json.RawMessage(original), json.RawMessage(pivot))
+	if err != nil {
+		return 0, err
+	}
+
+	baseline := &Event{
+		ID:         last.ID,
+		CreatedAt:  last.CreatedAt,
+		Initiator:  "system",
+		Subject:    last.Subject,
+		EntityID:   id,
+		EntityType: UserEntity,
+		Action:     UserBaselineAction,
+		Rollback:   rollback,
+		Update:     update,
+	}
+
+	collapsed := make(map[int]bool, len(collapse))
+	removedIDs := make([]int64, 0, len(collapse)-1)
+	for _, i := range collapse {
+		collapsed[i] = true
+		if s.events[i] != last {
+			removedIDs = append(removedIDs, s.events[i].ID)
+		}
+	}
+	err = s.logChange(&walRecord{Updated: baseline, Removed: removedIDs, AfterEventID: s.lastEventID})
+	if err != nil {
+		return 0, err
+	}
+	if s.backend != nil {
+		if err := s.backend.DeleteEvents(removedIDs...); err != nil {
+			return 0, err
+		}
+		if err := s.backend.AppendEvent(baseline); err != nil {
+			return 0, err
+		}
+	}
+	truncated := make([]*Event, 0, len(s.events)-len(collapse)+1)
+	for i, e := range s.events {
+		if e == last {
+			truncated = append(truncated, baseline)
+			continue
+		}
+		if collapsed[i] {
+			continue
+		}
+		truncated = append(truncated, e)
+	}
+	s.events = truncated
+	s.bumpVersion(id)
+
+	return len(collapse), nil
+}
+
+func rollUserForward(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	eventID, err := paramInt64(c, "event_id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	u, err := getStore(c).rollForward(entityID, eventID)
+	if err != nil {
+		if errors.Is(err, errEventOfOtherUser) {
+			return writeError(c, http.StatusNotFound, CodeNotFound, err.Error())
+		}
+		return writeLookupError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, u)
+}
+
+// rollForward rebuilds the state of the user right after the event with the
+// given id by applying, oldest first, the update patches of its chain to the
+// earliest known state.
+func (s *Store) rollForward(id, eventID int64) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, err := s.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := s.getEvent(eventID)
+	if err != nil {
+		return nil, err
+	}
+	if !e.changes(UserEntity, id) {
+		return nil, errEventOfOtherUser
+	}
+
+	if snap := s.latestSnapshot(UserEntity, id, eventID); snap != nil {
+		source, _, err := s.replayFromSnapshot(snap, eventID)
+		if err != nil {
+			return nil, err
+		}
+		state := &User{}
+		err = json.Unmarshal(source, state)
+		if err != nil {
+			return nil, err
+		}
+		return state, nil
+	}
+
+	base, err := s.rollbackEvents(u, func(e *Event) bool {
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	source, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range s.events {
+		if !e.changes(UserEntity, id) || e.ID > eventID {
+			continue
+		}
+		source, err = patch(e, UpdateType, source)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	state := &User{}
+	err = json.Unmarshal(source, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// rollbackEvent restores the user changed by an event to its state right
+// before that event, recording the change as a rollback event that reverts
+// the event and every later one of the user not reverted yet.
+func rollbackEvent(c echo.Context) error {
+	eventID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.rollbackTo(eventID, initiator(c), subject(c), eventMetadata(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotUserEvent):
+			return writeError(c, http.StatusUnprocessableEntity, CodeInvalidInput, err.Error())
+		case errors.Is(err, errUserLocked):
+			return writeError(c, http.StatusLocked, CodeLocked, err.Error())
+		}
+		return writeLookupError(c, err)
+	}
+	if u == nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return c.JSON(http.StatusOK, u)
+}
+
+// errNotUserEvent is returned when rolling back an event that changed an
+// entity other than a user.
+var errNotUserEvent = errors.New("only events of users can be rolled back")
+
+// rollbackTo makes the state of the user changed by the event with the given
+// id its state before that event, and returns it. It returns nil if the user
+// did not exist yet. The rollback event is initiated by initiator for subject
+// and carries metadata. The caller must hold s.mu.
+func (s *Store) rollbackTo(eventID int64, initiator, subject string, metadata map[string]string) (*User, error) {
+	e, err := s.getEvent(eventID)
+	if err != nil {
+		return nil, err
+	}
+	if e.entityType() != UserEntity {
+		return nil, fmt.Errorf("%w: it changed a %s", errNotUserEvent, e.entityType())
+	}
+	id := e.EntityID
+	old := s.users[id]
+	if old != nil && old.Locked {
+		return nil, errUserLocked
+	}
+
+	current, err := s.currentState(id)
+	if err != nil {
+		return nil, err
+	}
+	source, err := s.rollbackState(id, current, func(e *Event) bool {
+		return e.ID >= eventID
+	})
+	if err != nil {
+		return nil, err
+	}
+	var u *User
+	if string(source) != "null" {
+		u = &User{}
+		err = json.Unmarshal(source, u)
+		if err != nil {
+			return nil, err
+		}
+		// the rollback is a change of its own, not a return to the old version
+		if old != nil {
+			u.Version = old.Version + 1
+		} else {
+			u.Version++
+		}
+	}
+
+	rollback, err := s.newEvent(initiator, subject, id, UserRollbackAction, old, u)
+	if err != nil {
+		return nil, err
+	}
+	rollback.IsRollback = true
+	rollback.Metadata = metadata
+	if u == nil {
+		delete(s.users, id)
+		err = s.recordEvent(rollback, nil)
+	} else {
+		s.users[id] = u
+		err = s.recordEvent(rollback, u)
+	}
+	if err != nil {
+		if old == nil {
+			delete(s.users, id)
+		} else {
+			s.users[id] = old
+		}
+		return nil, err
+	}
+
+	for _, reverted := range s.events {
+		if reverted.ID >= eventID && reverted.ID != rollback.ID && reverted.changes(UserEntity, id) && reverted.RevertedByEventID == nil {
+			err = s.markReverted(reverted.ID, rollback.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return u, nil
+}
+
+// userHistory lists, oldest first and paginated like eventsList, the events
+// of a user, deleted ones included.
+func userHistory(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	limit, err := parseLimit(c)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	offset := 0
+	if c.QueryParam(OffsetParam) != "" {
+		offset, err = strconv.Atoi(c.QueryParam(OffsetParam))
+		if err != nil || offset < 0 {
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, "offset must be a non-negative integer")
+		}
+	}
+
+	events, err := getStore(c).userEvents(entityID)
+	if err != nil {
+		return writeLookupError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, paginate(events, limit, offset))
+}
+
+// userEvents returns the events of user id, oldest first. Users without
+// events nor state are not found.
+func (s *Store) userEvents(id int64) ([]*Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []*Event{}
+	for _, e := range s.events {
+		if e.changes(UserEntity, id) {
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
+		if _, err := s.getUser(id); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// fakeClock makes s stamp events at start, one minute later each time.
+func fakeClock(s *Store, start time.Time) {
+	now := start
+	s.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+}
+
+var clockStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestUsersAtSkipsUsersCreatedLater(t *testing.T) {
+	s := newSeededStore()
+	fakeClock(s, clockStart)
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Ann","age":30}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/3", `{"id":3,"name":"Bob","age":30}`), http.StatusOK)
+
+	rec := request(t, e, http.MethodGet, "/users/at?when=2000-01-01T00:00:00Z", "")
+	expectStatus(t, rec, http.StatusOK)
+	users := decodeBody[[]*User](t, rec)
+	if len(users) != 1 || users[0].ID != 1 {
+		t.Fatalf("users = %+v, want only the seeded one", users)
+	}
+
+	rec = request(t, e, http.MethodPost, "/users/diff", `{"from":"2000-01-01T00:00:00Z","to":"2030-01-01T00:00:00Z"}`)
+	expectStatus(t, rec, http.StatusOK)
+	diff := decodeBody[struct {
+		Added   []int64 `json:"added"`
+		Removed []int64 `json:"removed"`
+	}](t, rec)
+	if len(diff.Added) != 2 || diff.Added[0] != 2 || len(diff.Removed) != 0 {
+		t.Fatalf("diff = %+v", diff)
+	}
+}
+
+func TestUsersAtDoesNotHoldTheLockWhileWaiting(t *testing.T) {
+	s := newSeededStore()
+	for i := 0; i < maxConcurrentReconstructions; i++ {
+		reconstructLimiter <- struct{}{}
+	}
+	released := false
+	release := func() {
+		if released {
+			return
+		}
+		released = true
+		for i := 0; i < maxConcurrentReconstructions; i++ {
+			releaseReconstruct()
+		}
+	}
+	defer release()
+
+	usersAt := make(chan error, 1)
+	go func() {
+		_, err := s.getUsersAt(context.Background(), time.Now())
+		usersAt <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// a queued writer keeps new readers out while a reader holds s.mu
+	go func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	userAt := make(chan error, 1)
+	go func() {
+		_, err := s.getUserAt(1, time.Now())
+		userAt <- err
+	}()
+	select {
+	case err := <-userAt:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("getUserAt is blocked by getUsersAt waiting for the limiter")
+	}
+
+	release()
+	if err := <-usersAt; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUsersAt(t *testing.T) {
+	s := newSeededStore()
+	fakeClock(s, clockStart)
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"Ann","age":30,"version":1}`), http.StatusOK)
+
+	tests := []struct {
+		when string
+		name string
+	}{
+		{"2023-12-31T00:00:00Z", "John"},
+		{"2024-01-02T00:00:00Z", "Ann"},
+	}
+	for _, tt := range tests {
+		rec := request(t, e, http.MethodGet, "/users/at?when="+tt.when, "")
+		expectStatus(t, rec, http.StatusOK)
+		if states := decodeBody[[]*User](t, rec); len(states) != 1 || states[0].Name != tt.name {
+			t.Errorf("%s: users = %+v, want %s", tt.when, states, tt.name)
+		}
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/users/at?when=yesterday", ""), http.StatusBadRequest)
+}
+
+func TestOriginalUser(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":2,"name":"Ann","age":30}`), http.StatusCreated)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Bea","age":30,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/3", `{"id":3,"name":"Bob","age":30}`), http.StatusOK)
+
+	rec := request(t, e, http.MethodGet, "/user/2/original", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "Ann" {
+		t.Fatalf("original = %+v", u)
+	}
+	rec = request(t, e, http.MethodGet, "/user/1/original", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "John" {
+		t.Fatalf("seeded original = %+v", u)
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/user/3/original", ""), http.StatusNotFound)
+}
+
+func TestTruncateKeepsCreation(t *testing.T) {
+	s := newSeededStore()
+	fakeClock(s, clockStart)
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":2,"name":"Ann","age":30}`), http.StatusCreated)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Bea","age":30,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Cid","age":30,"version":2}`), http.StatusOK)
+
+	expectStatus(t, request(t, e, http.MethodPost, "/user/2/truncate?keep=1", ""), http.StatusOK)
+	if len(s.events) != 2 {
+		t.Fatalf("%d events after truncate, want 2", len(s.events))
+	}
+	baseline := s.events[0]
+	if baseline.Action != UserBaselineAction || baseline.EntityType != UserEntity {
+		t.Fatalf("baseline = %+v", baseline)
+	}
+	if string(baseline.Rollback) != `[{"op":"add","path":"","value":null}]` {
+		t.Fatalf("baseline rollback = %s, want a rollback to null", baseline.Rollback)
+	}
+
+	expectStatus(t, request(t, e, http.MethodGet, "/user/2/original", ""), http.StatusNotFound)
+	expectStatus(t, request(t, e, http.MethodGet, "/user/2/at?created_at=2024-01-01T00:00:30Z", ""), http.StatusNotFound)
+	rec := request(t, e, http.MethodGet, "/user/2/at?created_at=2024-01-01T00:02:30Z", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "Bea" {
+		t.Fatalf("user before the kept event = %+v", u)
+	}
+}
+
+func TestTruncateHistory(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	for i, name := range []string{"Ann", "Bea", "Cid"} {
+		expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", fmt.Sprintf(`{"id":1,"name":%q,"age":16,"version":%d}`, name, i+1)), http.StatusOK)
+	}
+
+	rec := request(t, e, http.MethodPost, "/user/1/truncate?keep=1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if res := decodeBody[map[string]int](t, rec); res["collapsed"] != 2 {
+		t.Fatalf("result = %+v", res)
+	}
+	if len(s.events) != 2 || s.events[0].ID != 2 || s.events[0].Action != UserBaselineAction || s.events[1].ID != 3 {
+		t.Fatalf("events after truncate = %+v", s.events)
+	}
+	before, err := s.rollbackEvents(s.users[1], func(e *Event) bool { return e.ID == 3 })
+	if err != nil || before.Name != "Bea" {
+		t.Fatalf("state before the kept event = %+v, %v", before, err)
+	}
+	rec = request(t, e, http.MethodGet, "/user/1/original", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "John" {
+		t.Fatalf("original = %+v", u)
+	}
+
+	rec = request(t, e, http.MethodPost, "/user/1/truncate?keep=5", "")
+	expectStatus(t, rec, http.StatusOK)
+	if res := decodeBody[map[string]int](t, rec); res["collapsed"] != 0 {
+		t.Fatalf("result = %+v", res)
+	}
+	expectStatus(t, request(t, e, http.MethodPost, "/user/1/truncate?keep=x", ""), http.StatusBadRequest)
+	expectStatus(t, request(t, e, http.MethodPost, "/user/9/truncate?keep=1", ""), http.StatusNotFound)
+}
+
+func TestRollUserForward(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	for i, name := range []string{"Ann", "Bea", "Cid"} {
+		expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", fmt.Sprintf(`{"id":1,"name":%q,"age":16,"version":%d}`, name, i+1)), http.StatusOK)
+	}
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Jo","age":20}`), http.StatusOK)
+
+	for id, name := range map[string]string{"1": "Ann", "2": "Bea", "3": "Cid"} {
+		rec := request(t, e, http.MethodGet, "/user/1/forward/"+id, "")
+		expectStatus(t, rec, http.StatusOK)
+		if u := decodeBody[User](t, rec); u.Name != name {
+			t.Errorf("forward to %s: user = %+v, want %s", id, u, name)
+		}
+	}
+	// event 4 changed another user
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1/forward/4", ""), http.StatusNotFound)
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1/forward/x", ""), http.StatusBadRequest)
+}
+
+func TestTruncateAndForwardErrors(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+
+	expectStatus(t, request(t, e, http.MethodPost, "/user/9/truncate?keep=1", ""), http.StatusNotFound)
+	expectStatus(t, request(t, e, http.MethodGet, "/user/9/forward/1", ""), http.StatusNotFound)
+	expectStatus(t, request(t, e, http.MethodGet, "/user/2/forward/9", ""), http.StatusNotFound)
+
+	// changed behind the event log
+	s.mu.Lock()
+	s.users[2] = &User{ID: 2, Name: "Zed", Age: 30, Version: 3}
+	s.mu.Unlock()
+
+	for _, tc := range []struct{ method, target string }{
+		{http.MethodPost, "/user/2/truncate?keep=1"},
+		{http.MethodGet, "/user/2/forward/2"},
+	} {
+		rec := request(t, e, tc.method, tc.target, "")
+		expectStatus(t, rec, http.StatusConflict)
+		if apiErr := decodeBody[APIError](t, rec); apiErr.Code != CodeConflict {
+			t.Fatalf("%s: error = %+v", tc.target, apiErr)
+		}
+	}
+}
+
+func TestRollbackEvent(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+
+	rec := request(t, e, http.MethodPost, "/events/2/rollback", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "Ann" || u.Version != 4 {
+		t.Fatalf("rolled back user = %+v, want Ann at version 4", u)
+	}
+	if s.users[2].Name != "Ann" {
+		t.Fatalf("stored user = %+v", s.users[2])
+	}
+	rollback := s.events[3]
+	if !rollback.IsRollback || rollback.Action != UserRollbackAction {
+		t.Fatalf("rollback event = %+v", rollback)
+	}
+	for _, reverted := range s.events[1:3] {
+		if reverted.RevertedByEventID == nil || *reverted.RevertedByEventID != rollback.ID {
+			t.Errorf("event %d is not reverted by %d", reverted.ID, rollback.ID)
+		}
+	}
+
+	rec = request(t, e, http.MethodGet, "/events?exclude_reverted=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	if list := decodeBody[EventsList](t, rec); list.Total != 2 || list.Events[0].ID != 1 || list.Events[1].ID != 4 {
+		t.Fatalf("events not reverted = %+v", list.Events)
+	}
+
+	// rolling back the creation removes the user
+	expectStatus(t, request(t, e, http.MethodPost, "/events/1/rollback", ""), http.StatusNoContent)
+	if _, ok := s.users[2]; ok {
+		t.Fatal("user 2 still exists")
+	}
+	expectStatus(t, request(t, e, http.MethodPost, "/events/42/rollback", ""), http.StatusNotFound)
+}
+
+func TestRollbackEventOfLockedUser(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+	expectStatus(t, request(t, e, http.MethodPost, "/user/2/lock", ""), http.StatusOK)
+
+	expectStatus(t, request(t, e, http.MethodPost, "/events/2/rollback", ""), http.StatusLocked)
+	if s.users[2].Name != "Cid" || len(s.events) != 4 {
+		t.Fatalf("rollback of a locked user changed it: %+v, %d events", s.users[2], len(s.events))
+	}
+}
+
+func TestUserHistory(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"Jo","age":16,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodDelete, "/user/2", ""), http.StatusNoContent)
+
+	rec := request(t, e, http.MethodGet, "/user/2/history?limit=2&offset=1", "")
+	expectStatus(t, rec, http.StatusOK)
+	page := decodeBody[EventsList](t, rec)
+	if page.Total != 4 || len(page.Events) != 2 || page.Events[0].ID != 2 || page.Events[1].ID != 3 {
+		t.Fatalf("history page = %+v", page)
+	}
+
+	rec = request(t, e, http.MethodGet, "/user/1/history", "")
+	expectStatus(t, rec, http.StatusOK)
+	if page := decodeBody[EventsList](t, rec); page.Total != 1 || page.Events[0].ID != 4 {
+		t.Fatalf("history of user 1 = %+v", page)
+	}
+
+	expectStatus(t, request(t, e, http.MethodGet, "/user/42/history", ""), http.StatusNotFound)
+	expectStatus(t, request(t, e, http.MethodGet, "/user/2/history?offset=-1", ""), http.StatusBadRequest)
+}
+
+// seedHistory creates user 2 as Ann, then renames it to Bea and Cid, one
+// minute apart from clockStart: events 1 to 3.
+func seedHistory(t *testing.T, e *echo.Echo) {
+	t.Helper()
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":2,"name":"Ann","age":30}`), http.StatusCreated)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Bea","age":30,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Cid","age":30,"version":2}`), http.StatusOK)
+}
+
+func TestUserAt(t *testing.T) {
+	s := newSeededStore()
+	fakeClock(s, clockStart)
+	e := newTestServer(s)
+	seedHistory(t, e)
+
+	tests := []struct {
+		when string
+		name string
+	}{
+		{"2024-01-01T00:01:30Z", "Ann"},
+		{"2024-01-01T00:02:00Z", "Bea"},
+		{"2024-01-01T00:02:30", "Bea"},
+		{"2024-01-02", "Cid"},
+	}
+	for _, tt := range tests {
+		rec := request(t, e, http.MethodGet, "/user/2/at?created_at="+tt.when, "")
+		expectStatus(t, rec, http.StatusOK)
+		if u := decodeBody[User](t, rec); u.Name != tt.name {
+			t.Errorf("%s: user = %+v, want %s", tt.when, u, tt.name)
+		}
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/user/2/at?created_at=2024-01-01T00:00:30Z", ""), http.StatusNotFound)
+	expectStatus(t, request(t, e, http.MethodGet, "/user/2/at?created_at=yesterday", ""), http.StatusBadRequest)
+	expectStatus(t, request(t, e, http.MethodGet, "/user/42/at?created_at=2024-01-02", ""), http.StatusNotFound)
+}
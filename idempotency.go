@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+
+	defaultIdempotencyTTL = 24 * time.Hour
+)
+
+// idempotentResponse is a response recorded for an idempotency key. Until
+// done is closed the request that got the key is still being served.
+type idempotentResponse struct {
+	done        chan struct{}
+	expires     time.Time
+	status      int
+	contentType string
+	location    string
+	body        []byte
+}
+
+// idempotencyCache holds the responses to the keyed requests until they
+// expire.
+type idempotencyCache struct {
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+}
+
+// claim returns the response recorded for key, or registers a pending one
+// and reports that the caller has to serve the request.
+func (ic *idempotencyCache) claim(key string, now time.Time) (*idempotentResponse, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	for k, r := range ic.responses {
+		if isClosed(r.done) && now.After(r.expires) {
+			delete(ic.responses, k)
+		}
+	}
+	if r, ok := ic.responses[key]; ok {
+		return r, false
+	}
+	r := &idempotentResponse{done: make(chan struct{})}
+	ic.responses[key] = r
+	return r, true
+}
+
+func (ic *idempotencyCache) forget(key string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	delete(ic.responses, key)
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// recordingWriter passes a response through while keeping a copy of its
+// body.
+type recordingWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotency answers POST, PUT and PATCH requests repeating the
+// Idempotency-Key of an earlier one within ttl with the response to that
+// one, without serving them again. Keys are scoped to the method, path and
+// principal of the request. Responses with a 5xx status are not kept, so
+// those requests can be retried.
+func idempotency(ttl time.Duration) echo.MiddlewareFunc {
+	cache := &idempotencyCache{responses: make(map[string]*idempotentResponse)}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			header := req.Header.Get(HeaderIdempotencyKey)
+			switch {
+			case header == "":
+				return next(c)
+			case req.Method != http.MethodPost && req.Method != http.MethodPut && req.Method != http.MethodPatch:
+				return next(c)
+			}
+
+			key := req.Method + " " + req.URL.Path + " " + initiator(c) + " " + header
+			recorded, first := cache.claim(key, time.Now())
+			if !first {
+				select {
+				case <-recorded.done:
+				case <-req.Context().Done():
+					return nil
+				}
+				if recorded.status == 0 {
+					return writeError(c, http.StatusConflict, CodeConflict, "request with this idempotency key failed, retry it")
+				}
+				if recorded.location != "" {
+					c.Response().Header().Set(echo.HeaderLocation, recorded.location)
+				}
+				return c.Blob(recorded.status, recorded.contentType, recorded.body)
+			}
+			defer close(recorded.done)
+
+			w := &recordingWriter{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = w
+			err := next(c)
+			c.Response().Writer = w.ResponseWriter
+			res := c.Response()
+			if err != nil || res.Status >= http.StatusInternalServerError {
+				cache.forget(key)
+				return err
+			}
+
+			recorded.expires = time.Now().Add(ttl)
+			recorded.status = res.Status
+			recorded.contentType = res.Header().Get(echo.HeaderContentType)
+			recorded.location = res.Header().Get(echo.HeaderLocation)
+			recorded.body = w.body.Bytes()
+			return nil
+		}
+	}
+}
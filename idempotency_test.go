@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestIdempotency(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	e.Use(idempotency(time.Hour))
+
+	body := `{"id":2,"name":"Ann","age":30}`
+	first := request(t, e, http.MethodPost, "/user", body, HeaderIdempotencyKey, "k1")
+	expectStatus(t, first, http.StatusCreated)
+	again := request(t, e, http.MethodPost, "/user", body, HeaderIdempotencyKey, "k1")
+	expectStatus(t, again, http.StatusCreated)
+	if again.Body.String() != first.Body.String() || again.Header().Get(echo.HeaderLocation) != "/user/2" {
+		t.Fatalf("repeated response %s, want %s", again.Body.String(), first.Body.String())
+	}
+	if len(s.events) != 1 {
+		t.Fatalf("%d events, want the create served once", len(s.events))
+	}
+
+	// other keys and unkeyed requests are served
+	expectStatus(t, request(t, e, http.MethodPost, "/user", body, HeaderIdempotencyKey, "k2"), http.StatusConflict)
+	expectStatus(t, request(t, e, http.MethodPost, "/user", body), http.StatusConflict)
+	// the key is scoped to the path
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Bea","age":30,"version":1}`, HeaderIdempotencyKey, "k1"), http.StatusOK)
+	if len(s.events) != 2 {
+		t.Fatalf("%d events, want 2", len(s.events))
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	e.Use(idempotency(time.Millisecond))
+
+	update := `{"id":1,"name":"Jo","age":16,"version":1}`
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", update, HeaderIdempotencyKey, "k"), http.StatusOK)
+	time.Sleep(5 * time.Millisecond)
+	// once the key expired the stale version is rejected again
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", update, HeaderIdempotencyKey, "k"), http.StatusConflict)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+var (
+	integrityChecks   = expvar.NewInt("integrity_checks")
+	integrityFailures = expvar.NewInt("integrity_failures")
+)
+
+// verifyUser walks the event chain of u newest first and checks that the
+// rollback of every event applies and that its update leads back to the
+// state the rollback started from.
+func (s *Store) verifyUser(u *User) error {
+	source, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	for i := len(s.events) - 1; i >= 0; i-- {
+		e := s.events[i]
+		if !e.changes(UserEntity, u.ID) {
+			continue
+		}
+		prev, err := patch(e, RollbackType, source)
+		if err != nil {
+			return fmt.Errorf("event %d: rollback: %w", e.ID, err)
+		}
+		redone, err := patch(e, UpdateType, prev)
+		if err != nil {
+			return fmt.Errorf("event %d: update: %w", e.ID, err)
+		}
+		equal, err := jsonEqual(source, redone)
+		if err != nil {
+			return fmt.Errorf("event %d: %w", e.ID, err)
+		}
+		if !equal {
+			return fmt.Errorf("event %d: update does not invert rollback", e.ID)
+		}
+		source = prev
+	}
+
+	return nil
+}
+
+func jsonEqual(a, b []byte) (bool, error) {
+	var av, bv any
+	if err := json.Unmarshal(a, &av); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, &bv); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(av, bv), nil
+}
+
+// verifyIntegrity checks the event chains of all users and returns the
+// failures by user id.
+func (s *Store) verifyIntegrity() map[int64]error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	failures := make(map[int64]error)
+	for id, u := range s.users {
+		if err := s.verifyUser(u); err != nil {
+			failures[id] = err
+		}
+	}
+	return failures
+}
+
+// runIntegrityChecker verifies all event chains every interval until ctx is
+// done.
+func (s *Store) runIntegrityChecker(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		failures := s.verifyIntegrity()
+		integrityChecks.Add(1)
+		integrityFailures.Set(int64(len(failures)))
+		for id, err := range failures {
+			logger.Warn("integrity check failed", "user_id", id, "error", err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestVerifyIntegrity(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"B","age":21,"version":2}`), http.StatusOK)
+
+	if failures := s.verifyIntegrity(); len(failures) != 0 {
+		t.Fatalf("failures = %v", failures)
+	}
+
+	// changed behind the event log
+	s.users[1] = &User{ID: 1, Name: "Zed", Age: 21}
+	failures := s.verifyIntegrity()
+	if len(failures) != 1 || failures[1] == nil {
+		t.Fatalf("failures = %v, want one for user 1", failures)
+	}
+}
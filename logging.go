@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+const loggerContextKey = "logger"
+
+var (
+	logLevel = new(slog.LevelVar)
+	logger   = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+)
+
+// withLogger attaches to every request a logger carrying the name of the
+// handler that serves it and the request ID. Echo wraps the handlers of its
+// routes, so the names come from the routes, listed on the first request once
+// they are all registered.
+func withLogger(l *slog.Logger) echo.MiddlewareFunc {
+	var once sync.Once
+	var names map[string]string
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			once.Do(func() { names = routeNames(c.Echo().Routes()) })
+			name, ok := names[c.Request().Method+" "+c.Path()]
+			if !ok {
+				name = handlerName(c.Handler())
+			}
+			c.Set(loggerContextKey, l.With("handler", name, "request_id", getRequestID(c)))
+			return next(c)
+		}
+	}
+}
+
+// routeNames maps the method and path of routes to the names of their
+// handlers.
+func routeNames(routes []*echo.Route) map[string]string {
+	names := make(map[string]string, len(routes))
+	for _, r := range routes {
+		names[r.Method+" "+r.Path] = shortFuncName(r.Name)
+	}
+	return names
+}
+
+// getLogger returns the request logger, or the global one outside withLogger.
+func getLogger(c echo.Context) *slog.Logger {
+	if l, ok := c.Get(loggerContextKey).(*slog.Logger); ok {
+		return l
+	}
+	return logger
+}
+
+func handlerName(h echo.HandlerFunc) string {
+	return shortFuncName(runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name())
+}
+
+// shortFuncName drops the package path from the full name of a function,
+// which is main for the server but the module path in its tests.
+func shortFuncName(name string) string {
+	name = name[strings.LastIndex(name, "/")+1:]
+	if _, short, ok := strings.Cut(name, "."); ok {
+		return short
+	}
+	return name
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
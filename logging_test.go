@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRequestLogging(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	e := newTestServer(newSeededStore())
+	e.Use(withLogger(l))
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`, echo.HeaderXRequestID, "req-1"), http.StatusOK)
+	line := out.String()
+	for _, field := range []string{`msg="user updated"`, "handler=updateUser", "request_id=req-1", "user_id=1", "version=2"} {
+		if !strings.Contains(line, field) {
+			t.Errorf("log %q lacks %s", line, field)
+		}
+	}
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"expvar"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
@@ -14,18 +23,25 @@ import (
 	"github.com/wI2L/jsondiff"
 )
 
-var (
-	global = time.Now()
-)
-
 type User struct {
 	ID      int64     `json:"id,omitempty"`
 	Name    string    `json:"name,omitempty"`
 	Age     int       `json:"age,omitempty"`
 	Bag     *Backpack `json:"bag,omitempty"`
 	IsAdult bool      `json:"is_adult,omitempty"`
+	Locked  bool      `json:"locked,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+	// Deleted marks users removed by deleteUser, which are kept for their
+	// history but hidden from lookups
+	Deleted bool `json:"deleted,omitempty"`
+	// Version counts the changes of the user, updates must carry the
+	// current one
+	Version int64 `json:"version,omitempty"`
 }
 
+// Backpack is the bag of a user. A nil bag is omitted, so giving a user a bag
+// or taking it away records an add or a remove of the whole /bag, which both
+// reverse. An empty bag is kept as {} and stays distinct from no bag.
 type Backpack struct {
 	Phone string `json:"phone,omitempty"`
 	Food  string `json:"food,omitempty"`
@@ -37,119 +53,666 @@ type Event struct {
 	CreatedAt  time.Time `json:"created_at,omitempty"`
 	Initiator  string    `json:"initiator,omitempty"`
 	Subject    string    `json:"subject,omitempty"`
+	EntityID   int64     `json:"entity_id,omitempty"`
+	EntityType string    `json:"entity_type,omitempty"`
 	Action     string    `json:"action,omitempty"`
-	Rollback   any       `json:"rollback,omitempty"`
-	Update     any       `json:"update,omitempty"`
-	IsRollback bool      `json:"is_rollback,omitempty"`
+	// Rollback and Update hold serialized RFC 6902 patches, the same in
+	// memory and after reloading the events
+	Rollback   json.RawMessage `json:"rollback,omitempty"`
+	Update     json.RawMessage `json:"update,omitempty"`
+	IsRollback bool            `json:"is_rollback,omitempty"`
+
+	RevertedByEventID *int64 `json:"reverted_by_event_id,omitempty"`
+
+	// Snapshot is the full state of the entity, set on snapshot events
+	Snapshot            json.RawMessage `json:"snapshot,omitempty"`
+	SupersededByEventID *int64          `json:"superseded_by_event_id,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 const (
 	RollbackType = "rollback"
 	UpdateType   = "update"
 
-	CreatedAtParam = "created_at"
+	UserCreateAction   = "user_create"
+	UserDeleteAction   = "user_delete"
+	UserBaselineAction = "user_baseline"
+	UserRollbackAction = "user_rollback"
+
+	CreatedAtParam       = "created_at"
+	CreatedFromParam     = "created_from"
+	CreatedToParam       = "created_to"
+	ExcludeRevertedParam = "exclude_reverted"
+	InitiatorParam       = "initiator"
+	SubjectParam         = "subject"
+	ActionParam          = "action"
+	AsParam              = "as"
+	MetaParam            = "meta"
+	PreviewParam         = "preview"
+
+	AsJSONPatch          = "jsonpatch"
+	MIMEApplicationPatch = "application/json-patch+json"
 )
 
 var (
-	users = map[int64]*User{
-		1: {
-			ID:   1,
-			Name: "John",
-			Age:  16,
-			Bag: &Backpack{
-				Phone: "Poco F3",
-				Food:  "Big tasty",
-				Gun:   "Beretta",
-			}},
-	}
-	events = []*Event{}
+	errUserLocked      = errors.New("user is locked")
+	errStateDrift      = errors.New("state drift")
+	errDuplicateUserID = errors.New("duplicate user id")
+	errUserNotFound    = errors.New("user with this id not exist")
+	errEventNotFound   = errors.New("event with this id not exist")
+)
+
+// patchTypes are the patch types of an event, by name.
+var patchTypes = map[string]bool{RollbackType: true, UpdateType: true}
+
+var errUnknownPatchType = fmt.Errorf("patch type must be one of %s", strings.Join(sortedKeys(patchTypes), ", "))
+
+var (
+	strictPatchOps  = false
+	allowedPatchOps = map[string]bool{"add": true, "remove": true, "replace": true, "test": true}
+	// skipPaths are the JSON pointers whose changes, nested ones included,
+	// recorded events leave out. Those changes can't be rolled back nor
+	// replayed, so none are skipped unless configured.
+	skipPaths = []string{}
 )
 
 func main() {
+	skip := flag.String("skip-paths", "", "comma-separated JSON pointers whose changes are left out of events and can't be rolled back")
+	allowedOps := flag.String("allowed-ops", "add,remove,replace,test", "comma-separated patch operations allowed in strict mode")
+	flag.BoolVar(&strictPatchOps, "strict-patch", false, "reject patch operations not listed in -allowed-ops")
+	addrFlag := flag.String("addr", defaultAddr, "listen address, overrides $"+addrEnv)
+	storeKind := flag.String("store", "memory", "storage backend: memory or sqlite")
+	sqlitePath := flag.String("sqlite-path", "dt-server.db", "path of the SQLite database used by -store=sqlite")
+	dataPath := flag.String("data", "", "path of the JSON snapshot the store is loaded from and saved to, in memory only when empty")
+	walPath := flag.String("wal", "", "path of the write-ahead log, disabled when empty")
+	secret := flag.String("cursor-secret", "", "key signing event page cursors, random when empty")
+	integrityInterval := flag.Duration("integrity-interval", 0, "interval of the background event chain check, disabled when 0")
+	flag.BoolVar(&allowSkipAudit, "allow-skip-audit", false, "honor the X-Skip-Audit header on mutating requests")
+	maxURL := flag.Int("max-url", defaultMaxURLLength, "maximum request URL length in bytes")
+	maxBody := flag.Int64("max-body", defaultMaxBodySize, "maximum request body size in bytes, unlimited when 0")
+	compactOnStart := flag.Bool("compact", false, "snapshot every user changed since its latest snapshot at startup")
+	defaults := flag.String("user-defaults", "", "JSON user whose fields fill in the ones omitted by created users")
+	apiKeys := flag.String("api-key", os.Getenv(apiKeysEnv), "comma-separated principal:key pairs required by mutating requests and the /admin and /debug routes, defaults to $"+apiKeysEnv+"; when empty, mutating requests are open and /admin and /debug are disabled")
+	allowOrigins := flag.String("allow-origins", "*", "comma-separated origins allowed to call the API cross-origin")
+	retention := RetentionPolicy{}
+	flag.IntVar(&retention.Days, "retain-days", 0, "days of events kept per user, the older ones are collapsed; unlimited when 0")
+	flag.IntVar(&retention.Count, "retain-count", 0, "latest events always kept per user when pruning by -retain-days, or the only ones kept without it; unlimited when 0")
+	idempotencyTTL := flag.Duration("idempotency-ttl", defaultIdempotencyTTL, "how long responses are replayed for repeated Idempotency-Key headers, disabled when 0")
+	rateFlag := flag.Float64("rate", defaultRate, "requests per second allowed per client IP, unlimited when 0")
+	flag.TextVar(logLevel, "log-level", slog.LevelInfo, "minimum level of logged messages: debug, info, warn or error")
+	flag.Parse()
+	allowedPatchOps = parseOpList(*allowedOps)
+	paths, err := parsePathList(*skip)
+	if err != nil {
+		fatal("parse skip paths", "error", err)
+	}
+	skipPaths = paths
+	addr, err := listenAddr(*addrFlag)
+	if err != nil {
+		fatal("resolve listen address", "error", err)
+	}
+	userDefaults, err = parseUserDefaults(*defaults)
+	if err != nil {
+		fatal("parse user defaults", "error", err)
+	}
+	keys, err := parseAPIKeys(*apiKeys)
+	if err != nil {
+		fatal("parse api keys", "error", err)
+	}
+	if *secret != "" {
+		cursorSecret = []byte(*secret)
+	}
+
+	store := newSeededStore()
+	switch *storeKind {
+	case "memory":
+	case "sqlite":
+		backend, err := OpenSQLite(*sqlitePath)
+		if err != nil {
+			fatal("open sqlite", "error", err)
+		}
+		defer backend.Close()
+		store.backend = backend
+		err = store.loadFromBackend()
+		if err != nil {
+			fatal("load sqlite", "error", err)
+		}
+	default:
+		fatal("unknown store", "store", *storeKind)
+	}
+	if *dataPath != "" {
+		err = store.LoadFromFile(*dataPath)
+		if err != nil {
+			fatal("load store", "error", err)
+		}
+	}
+	if *walPath != "" {
+		n, err := store.replayWAL(*walPath)
+		if err != nil {
+			fatal("replay wal", "error", err)
+		}
+		logger.Info("replayed wal", "records", n)
+
+		store.wal, err = OpenWAL(*walPath)
+		if err != nil {
+			fatal("open wal", "error", err)
+		}
+		defer store.wal.Close()
+	}
+
+	if *compactOnStart {
+		n, err := store.compact()
+		if err != nil {
+			fatal("compact", "error", err)
+		}
+		logger.Info("compacted", "snapshots", n)
+	}
+
+	if retention.enabled() {
+		n, err := store.prune(retention)
+		if err != nil {
+			fatal("prune events", "error", err)
+		}
+		logger.Info("pruned events", "events", n)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *integrityInterval > 0 {
+		go store.runIntegrityChecker(ctx, *integrityInterval)
+	}
+	if retention.enabled() {
+		go store.runRetention(ctx, retention, retentionInterval)
+	}
+
 	r := echo.New()
+	r.JSONSerializer = timeJSONSerializer{}
+	// rate limits go by the connection, not by forwarding headers any client
+	// can set
+	r.IPExtractor = echo.ExtractIPDirect()
+	r.Pre(requestID)
+	r.Pre(maxURLLength(*maxURL))
+	if *maxBody > 0 {
+		r.Pre(maxBodySize(*maxBody))
+	}
+	r.Use(cors(*allowOrigins))
+	if *rateFlag > 0 {
+		r.Use(rateLimit(*rateFlag))
+	}
+	if len(keys) > 0 {
+		r.Use(requireAPIKey(keys))
+	} else {
+		r.Use(closeProtected)
+	}
+	if *idempotencyTTL > 0 {
+		r.Use(idempotency(*idempotencyTTL))
+	}
+	r.Use(withLogger(logger))
+	r.Use(withStore(store))
+	if *dataPath != "" {
+		r.Use(saveAfterWrite(store, *dataPath))
+	}
+	r.Use(checkTimeFormat)
+	registerRoutes(r)
+
+	var flush func() error
+	if *dataPath != "" {
+		flush = func() error { return store.SaveToFile(*dataPath) }
+	}
+
+	logger.Info("listening", "addr", addr)
+	err = serve(ctx, r, addr, shutdownTimeout, flush)
+	if err != nil {
+		logger.Error("serve", "error", err)
+	}
+}
+
+func registerRoutes(r *echo.Echo) {
+	r.GET("/healthz", healthz)
+	r.GET("/openapi.json", openAPI)
 	r.GET("/parse_date", parseDate)
+	r.POST("/user", createUser)
 	r.PUT("/user/update/:id", updateUser)
+	r.DELETE("/user/:id", deleteUser)
+	r.PATCH("/user/:id", patchUser)
+	r.PATCH("/user/:id/merge", mergeUser)
+	r.PUT("/users/bulk", bulkUpdateUsers)
 	r.GET("/user/:id", getUserByID)
+	r.GET("/user/:id/original", getOriginalUser)
+	r.GET("/user/:id/at", userAt)
+	r.GET("/user/:id/history", userHistory)
+	r.GET("/user/:id/forward/:event_id", rollUserForward)
+	r.GET("/user/:id/diff/:event_id", userEventDiff)
+	r.POST("/user/:id/truncate", truncateUserHistory)
+	r.POST("/user/:id/dry-event", dryRunEvent)
+	r.POST("/user/:id/lock", lockUser)
+	r.POST("/user/:id/unlock", unlockUser)
+	r.POST("/user/:id/redo", redoUser)
+	r.POST("/user/:id/restore/:event_id", restoreUser)
+	r.GET("/users", listUsers)
+	r.GET("/users/at", usersAt)
+	r.GET("/users/aggregate", aggregateUsers)
+	r.POST("/users/diff", diffUsers)
+	r.POST("/diff", diffUser)
+	r.POST("/reconstruct", reconstructUser)
+	r.GET("/stats", eventStats)
 	r.GET("/events", eventsList)
+	r.GET("/event/:id", getEventByID)
+	r.GET("/events/replay", replayEvents)
+	r.GET("/events/stream", streamEvents)
+	r.GET("/events/pages", eventsPages)
+	r.GET("/events/find", findEvents)
+	r.GET("/events/export", exportEvents)
+	r.POST("/events/import", importEvents)
+	r.POST("/events/:id/apply-to/:entity_id", applyEventTo)
+	r.POST("/events/:id/rollback", rollbackEvent)
 	r.GET("/patch/:patch_type/:event_id/:entity_id", getPatchedByEventID)
-	r.Start(":8080")
+
+	admin := r.Group("/admin")
+	admin.GET("/stats", adminStats)
+	admin.GET("/dump", adminDump)
+	admin.POST("/load", adminLoad)
+	admin.POST("/compact", adminCompact)
+	r.GET("/debug/vars", echo.WrapHandler(expvar.Handler()))
 }
 
 func parseDate(c echo.Context) error {
 	if c.QueryParam(CreatedAtParam) != "" {
-		fmt.Printf("parseDate query param: %s\n", c.QueryParam(CreatedAtParam))
-		date, err := time.Parse("2006-01-02", c.QueryParam(CreatedAtParam))
+		date, err := parseFlexibleDate(c.QueryParam(CreatedAtParam))
 		if err != nil {
-			fmt.Println(err)
-			return c.JSON(http.StatusBadRequest, "parse error")
+			getLogger(c).Debug("parse date", "value", c.QueryParam(CreatedAtParam), "error", err)
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
 		}
 
-		fmt.Printf("parseDate parsed time: %s\n", date)
-		return c.JSON(http.StatusBadRequest, date)
+		return c.JSON(http.StatusOK, date)
 	}
 
-	return c.JSON(http.StatusBadRequest, "empty param")
+	return writeError(c, http.StatusBadRequest, CodeBadRequest, "created_at param is required")
 }
 
 func eventsList(c echo.Context) error {
+	filters := eventFilters(c)
+	limit, err := parseLimit(c)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	offset := 0
+	if c.QueryParam(OffsetParam) != "" {
+		offset, err = strconv.Atoi(c.QueryParam(OffsetParam))
+		if err != nil || offset < 0 {
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, "offset must be a non-negative integer")
+		}
+	}
+	var afterID int64
+	if c.QueryParam(AfterIDParam) != "" {
+		if c.QueryParam(OffsetParam) != "" {
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, "offset and after_id are exclusive")
+		}
+		afterID, err = strconv.ParseInt(c.QueryParam(AfterIDParam), 10, 64)
+		if err != nil || afterID < 0 {
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, "after_id must be a non-negative integer")
+		}
+	}
+
+	events, err := getStore(c).getEventsList(filters)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	page := paginate(eventsAfter(events, afterID), limit, offset)
+	if n := len(page.Events); n > 0 && offset+n < page.Total {
+		page.NextAfterID = page.Events[n-1].ID
+	}
+
+	if c.QueryParam(AsParam) == AsJSONPatch {
+		updates := make([]any, len(page.Events))
+		for i, e := range page.Events {
+			updates[i] = e.Update
+		}
+		return jsonPatchResponse(c, updates...)
+	}
+
+	return jsonWithETag(c, page, c.QueryParams().Encode())
+}
+
+// eventFilterParams are the query parameters filtering events.
+var eventFilterParams = []string{CreatedAtParam, CreatedFromParam, CreatedToParam, ExcludeRevertedParam, InitiatorParam, SubjectParam, ActionParam}
+
+// eventFilters returns the getEventsList filters set in the query.
+func eventFilters(c echo.Context) map[string]string {
 	filters := make(map[string]string)
-	if c.QueryParam(CreatedAtParam) != "" {
-		filters[CreatedAtParam] = c.QueryParam(CreatedAtParam)
+	for _, param := range eventFilterParams {
+		if c.QueryParam(param) != "" {
+			filters[param] = c.QueryParam(param)
+		}
+	}
+	return filters
+}
+
+type EventsList struct {
+	Events []*Event `json:"events"`
+	// Total counts the events matching the filters, after after_id if given
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	// NextAfterID is the after_id of the next page, unset on the last one
+	NextAfterID int64 `json:"next_after_id,omitempty"`
+}
+
+// eventsAfter drops the events up to and including the one with id afterID.
+// Unlike offsets, the position stays put when events are appended.
+func eventsAfter(events []*Event, afterID int64) []*Event {
+	if afterID == 0 {
+		return events
+	}
+	after := []*Event{}
+	for _, e := range events {
+		if e.ID > afterID {
+			after = append(after, e)
+		}
+	}
+	return after
+}
+
+// paginate cuts the page [offset, offset+limit) out of the filtered events.
+// Offsets past the end give an empty page.
+func paginate(events []*Event, limit, offset int) *EventsList {
+	page := &EventsList{Events: []*Event{}, Total: len(events), Limit: limit, Offset: offset}
+	if offset >= len(events) {
+		return page
+	}
+	end := offset + limit
+	if end > len(events) {
+		end = len(events)
+	}
+	page.Events = events[offset:end]
+	return page
+}
+
+func getEventByID(c echo.Context) error {
+	eventID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.RLock()
+	e, err := s.getEvent(eventID)
+	s.mu.RUnlock()
+	if err != nil {
+		return writeError(c, http.StatusNotFound, CodeNotFound, err.Error())
+	}
+
+	if c.QueryParam(AsParam) == AsJSONPatch {
+		return jsonPatchResponse(c, e.Update)
+	}
+
+	return c.JSON(http.StatusOK, e)
+}
+
+// jsonPatchResponse writes the given patches, in order, as one bare RFC 6902
+// document.
+func jsonPatchResponse(c echo.Context, patches ...any) error {
+	ops := jsonpatch.Patch{}
+	for _, p := range patches {
+		decoded, err := convertToPatch(p)
+		if err != nil {
+			return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		}
+		ops = append(ops, decoded...)
 	}
 
-	events, err := getEventsList(filters)
+	serialized, err := json.Marshal(ops)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, "bad request")
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
 	}
 
-	return c.JSON(http.StatusOK, events)
+	return c.Blob(http.StatusOK, MIMEApplicationPatch, serialized)
 }
 
-func getEventsList(filters map[string]string) ([]*Event, error) {
-	date, err := time.Parse(time.RFC3339, filters[CreatedAtParam])
+func (s *Store) getEventsList(filters map[string]string) ([]*Event, error) {
+	var date time.Time
+	if filters[CreatedAtParam] != "" {
+		var err error
+		date, err = parseFlexibleDate(filters[CreatedAtParam])
+		if err != nil {
+			return nil, err
+		}
+	}
+	from, err := parseOptionalTime(filters[CreatedFromParam])
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseOptionalTime(filters[CreatedToParam])
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
-	fmt.Printf("getEventsList parsed time: %s\n", date)
+	if from != nil && to != nil && from.After(*to) {
+		return nil, errors.New("created_from must not be after created_to")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// the backend evaluates the filters itself, once they are known to be
+	// valid
+	if s.backend != nil {
+		return s.backend.ListEvents(filters)
+	}
+
+	excludeReverted, _ := strconv.ParseBool(filters[ExcludeRevertedParam])
 	eventsList := []*Event{}
-	for _, e := range events {
+	for _, e := range s.events {
 		if e.CreatedAt.Before(date) {
 			continue
 		}
+		if (from != nil && e.CreatedAt.Before(*from)) || (to != nil && e.CreatedAt.After(*to)) {
+			continue
+		}
+		if excludeReverted && e.RevertedByEventID != nil {
+			continue
+		}
+		if !matchesFilter(filters, InitiatorParam, e.Initiator) ||
+			!matchesFilter(filters, SubjectParam, e.Subject) ||
+			!matchesFilter(filters, ActionParam, e.Action) {
+			continue
+		}
 		eventsList = append(eventsList, e)
 	}
 	return eventsList, nil
 }
 
-func addEvent(initiator, subject, action string, oldData, newData any) error {
-	rollback, update, err := extractDiffs(oldData, newData)
+// matchesFilter reports whether value exactly matches filters[name], if that
+// filter is set.
+func matchesFilter(filters map[string]string, name, value string) bool {
+	want, ok := filters[name]
+	return !ok || want == value
+}
+
+func parseOptionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := parseFlexibleDate(value)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &t, nil
+}
+
+// paramInt64 parses the path parameter name as a 64-bit integer.
+func paramInt64(c echo.Context, name string) (int64, error) {
+	value, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("path parameter %s must be a 64-bit integer", name)
+	}
+	return value, nil
+}
+
+// dateLayouts are the layouts accepted by parseFlexibleDate, in the order they
+// are tried.
+var dateLayouts = []string{time.RFC3339, "2006-01-02", "2006-01-02T15:04:05"}
+
+var errDateLayout = fmt.Errorf("date must match one of the layouts %s", strings.Join(dateLayouts, ", "))
 
-	id := int64(len(events) + 1)
+// parseFlexibleDate parses value with the first of dateLayouts that matches
+// it. Layouts without a zone are read as UTC.
+func parseFlexibleDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errDateLayout
+}
 
-	if len(events) > 5 {
-		global = global.Add(time.Hour * 24)
+// addEvent records the change of an entity from oldData to newData. The
+// caller must hold s.mu, which serializes the appends of concurrent requests,
+// so no append is lost. Computing the two patches dominates the cost of an
+// append: BenchmarkAddEvent measures about 150 allocations, some 8 KB, for
+// a small user, while the locking allocates nothing.
+func (s *Store) addEvent(initiator, subject string, entityID int64, action string, oldData, newData any, metadata map[string]string) error {
+	event, err := s.newEvent(initiator, subject, entityID, action, oldData, newData)
+	if err != nil {
+		return err
 	}
-	event := &Event{
-		ID:        id,
-		CreatedAt: global,
-		Initiator: initiator,
-		Subject:   subject,
-		Action:    action,
-		Rollback:  rollback,
-		Update:    update,
+	event.Metadata = metadata
+	return s.recordEvent(event, newData)
+}
+
+// recordEvent appends event, built by newEvent, to the log. newData is the
+// state of the entity after it.
+func (s *Store) recordEvent(event *Event, newData any) error {
+	entityID := event.EntityID
+	rec, err := newWALRecord(event, newData)
+	if err != nil {
+		return err
+	}
+	err = s.logChange(rec)
+	if err != nil {
+		return err
+	}
+	if s.backend != nil {
+		err = s.backend.AppendEvent(event)
+		if err != nil {
+			return err
+		}
+		err = s.saveState(entityID, newData)
+		if err != nil {
+			return err
+		}
+	}
+	s.events = append(s.events, event)
+	s.lastEventID = event.ID
+	s.bumpVersion(entityID)
+	if s.batch == nil {
+		s.stream.publish(event)
+	}
+	logger.Debug("event recorded", "event_id", event.ID, "user_id", entityID, "action", event.Action)
+
+	return nil
+}
+
+// newEvent builds the event describing the change from oldData to newData
+// without recording it.
+func (s *Store) newEvent(initiator, subject string, entityID int64, action string, oldData, newData any) (*Event, error) {
+	rollback, update, err := extractRawDiffs(oldData, newData)
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("event created at: %v\n", event.CreatedAt.Format(time.RFC3339))
-	events = append(events, event)
+	return &Event{
+		ID:         s.nextEventID(),
+		CreatedAt:  s.now(),
+		Initiator:  initiator,
+		Subject:    subject,
+		EntityID:   entityID,
+		EntityType: UserEntity,
+		Action:     action,
+		Rollback:   rollback,
+		Update:     update,
+	}, nil
+}
+
+// markReverted links the event with the given id to the rollback event that
+// undid it.
+func (s *Store) markReverted(id, revertedBy int64) error {
+	for i, e := range s.events {
+		if e.ID == id {
+			return s.updateEvent(i, func(e *Event) {
+				e.RevertedByEventID = &revertedBy
+			})
+		}
+	}
+	return errEventNotFound
+}
 
+// updateEvent replaces the i-th event of the log with a copy changed by
+// change. Appended events are never changed in place, as readers marshal the
+// events they collected after releasing s.mu. The caller must hold s.mu.
+func (s *Store) updateEvent(i int, change func(e *Event)) error {
+	updated := *s.events[i]
+	change(&updated)
+	err := s.logChange(&walRecord{Updated: &updated, AfterEventID: s.lastEventID})
+	if err != nil {
+		return err
+	}
+	s.events[i] = &updated
+	if s.backend != nil {
+		return s.backend.AppendEvent(&updated)
+	}
 	return nil
 }
 
+// extractDiffs returns the rollback and update patches between oldData and
+// newData. They are invertible, as the event log needs them to be.
 func extractDiffs(oldData, newData interface{}) (jsondiff.Patch, jsondiff.Patch, error) {
+	return diffPatches(oldData, newData, true)
+}
+
+// extractRawDiffs is extractDiffs returning the patches serialized, as
+// events hold them.
+func extractRawDiffs(oldData, newData any) (json.RawMessage, json.RawMessage, error) {
+	rollback, update, err := extractDiffs(oldData, newData)
+	if err != nil {
+		return nil, nil, err
+	}
+	rawRollback, err := json.Marshal(withoutSkippedPaths(rollback))
+	if err != nil {
+		return nil, nil, err
+	}
+	rawUpdate, err := json.Marshal(withoutSkippedPaths(update))
+	if err != nil {
+		return nil, nil, err
+	}
+	return rawRollback, rawUpdate, nil
+}
+
+// withoutSkippedPaths drops the operations of p on skipPaths.
+func withoutSkippedPaths(p jsondiff.Patch) jsondiff.Patch {
+	if len(skipPaths) == 0 {
+		return p
+	}
+	kept := make(jsondiff.Patch, 0, len(p))
+	for _, op := range p {
+		if !isSkippedPath(string(op.Path)) {
+			kept = append(kept, op)
+		}
+	}
+	return kept
+}
+
+func isSkippedPath(path string) bool {
+	for _, skipped := range skipPaths {
+		if path == skipped || strings.HasPrefix(path, skipped+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func diffPatches(oldData, newData any, invertible bool) (jsondiff.Patch, jsondiff.Patch, error) {
 	oldSerialized, err := json.Marshal(oldData)
 	if err != nil {
 		return nil, nil, err
@@ -159,12 +722,12 @@ func extractDiffs(oldData, newData interface{}) (jsondiff.Patch, jsondiff.Patch,
 		return nil, nil, err
 	}
 
-	updatePatch, err := createPatch(oldSerialized, newSerialized)
+	updatePatch, err := createPatch(oldSerialized, newSerialized, invertible)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	rollbackPatch, err := createPatch(newSerialized, oldSerialized)
+	rollbackPatch, err := createPatch(newSerialized, oldSerialized, invertible)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -172,8 +735,15 @@ func extractDiffs(oldData, newData interface{}) (jsondiff.Patch, jsondiff.Patch,
 	return rollbackPatch, updatePatch, nil
 }
 
-func createPatch(before, after []byte) (jsondiff.Patch, error) {
-	patch, err := jsondiff.CompareJSONOpts(before, after, jsondiff.Invertible())
+// createPatch diffs before and after. Invertible patches test every value
+// they replace or remove, so they can be verified and reversed; minimal ones
+// only hold the changes.
+func createPatch(before, after []byte, invertible bool) (jsondiff.Patch, error) {
+	opts := []jsondiff.Option{}
+	if invertible {
+		opts = append(opts, jsondiff.Invertible())
+	}
+	patch, err := jsondiff.CompareJSONOpts(before, after, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -182,93 +752,605 @@ func createPatch(before, after []byte) (jsondiff.Patch, error) {
 }
 
 func getUserByID(c echo.Context) error {
-	entityID, err := strconv.Atoi(c.Param("id"))
+	entityID, err := paramInt64(c, "id")
 	if err != nil {
-		log.Println("get user by id: ", err)
-		return c.JSON(http.StatusBadRequest, err.Error())
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	fields, err := parseFields(c.QueryParam(FieldsParam))
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
 	}
 
-	u, err := getUser(int64(entityID))
+	s := getStore(c)
+	s.mu.RLock()
+	u, err := s.lookupUser(entityID, includeDeleted(c))
+	s.mu.RUnlock()
 	if err != nil {
-		return c.JSON(http.StatusOK, err.Error())
+		return writeError(c, http.StatusNotFound, CodeNotFound, err.Error())
 	}
 
-	return c.JSON(http.StatusOK, u)
+	// the body holds the version of the user, so its ETag changes with it
+	// unless ?fields= leaves it out
+	if fields != nil {
+		masked, err := maskUser(u, fields)
+		if err != nil {
+			return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		}
+		return jsonWithETag(c, masked, c.QueryParams().Encode())
+	}
+	return jsonWithETag(c, u, c.QueryParams().Encode())
 }
 
 func getPatchedByEventID(c echo.Context) error {
 	patchType := c.Param("patch_type")
-	eventID, err := strconv.Atoi(c.Param("event_id"))
+	if !patchTypes[patchType] {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, errUnknownPatchType.Error())
+	}
+	eventID, err := paramInt64(c, "event_id")
 	if err != nil {
-		log.Println("get event_id: ", err)
-		return c.JSON(http.StatusBadRequest, err.Error)
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
 	}
-	entityID, err := strconv.Atoi(c.Param("entity_id"))
+	entityID, err := paramInt64(c, "entity_id")
 	if err != nil {
-		log.Println("get entity_id: ", err)
-		return c.JSON(http.StatusBadRequest, err.Error())
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
 	}
-	patched, err := getPatched(patchType, int64(eventID), int64(entityID))
+	patched, replayed, err := getStore(c).getPatched(patchType, eventID, entityID)
 	if err != nil {
-		log.Println(err)
-		return c.JSON(http.StatusBadRequest, err)
+		getLogger(c).Warn("reconstruct user", "event_id", eventID, "user_id", entityID, "error", err)
+		return writeLookupError(c, err)
+	}
+
+	meta, _ := strconv.ParseBool(c.QueryParam(MetaParam))
+	preview, _ := strconv.ParseBool(c.QueryParam(PreviewParam))
+	if meta || preview {
+		resp := &PatchedResponse{
+			PatchType:       patchType,
+			EventID:         eventID,
+			EventsReplayed:  replayed,
+			ReconstructedAt: time.Now().UTC(),
+			User:            patched,
+		}
+		if preview {
+			s := getStore(c)
+			s.mu.RLock()
+			current := s.users[entityID]
+			_, resp.Diff, err = extractDiffs(current, patched)
+			s.mu.RUnlock()
+			if err != nil {
+				return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+			}
+		}
+		return c.JSON(http.StatusOK, resp)
 	}
 
 	return c.JSON(http.StatusOK, patched)
 }
 
+// PatchedResponse describes how the user returned by getPatchedByEventID was
+// reconstructed. With ?preview=true it also holds the patch that would turn
+// the current user into the reconstructed one.
+type PatchedResponse struct {
+	PatchType       string         `json:"patch_type"`
+	EventID         int64          `json:"event_id"`
+	EventsReplayed  int            `json:"events_replayed"`
+	ReconstructedAt time.Time      `json:"reconstructed_at"`
+	User            *User          `json:"user"`
+	Diff            jsondiff.Patch `json:"diff,omitempty"`
+}
+
+// UserUpdate is the response to updateUser: the patches of the change and
+// the event recording it, which is missing when the audit was skipped or
+// the update changed nothing.
+type UserUpdate struct {
+	EventID   int64           `json:"event_id,omitempty"`
+	Update    json.RawMessage `json:"update"`
+	Rollback  json.RawMessage `json:"rollback"`
+	Unchanged bool            `json:"unchanged,omitempty"`
+}
+
+// unchangedUpdate answers updates that change nothing, which record no event.
+var unchangedUpdate = UserUpdate{Unchanged: true, Update: json.RawMessage("[]"), Rollback: json.RawMessage("[]")}
+
+// prepareUpdate checks the update of a user to u, the version the client
+// expects the stored one to have, and fills in what clients do not decide:
+// defaults of new users, the lock and delete state, the next version and the
+// derived fields. It returns the stored user, nil for a new one, and u as the
+// update records it. The caller must hold s.mu.
+func (s *Store) prepareUpdate(u *User, expected int64) (*User, *User, error) {
+	old := s.users[u.ID]
+	if old != nil && old.Deleted {
+		return nil, nil, errUserNotFound
+	}
+	var err error
+	if old == nil {
+		u, err = applyUserDefaults(u)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	err = validateUser(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = validateTags(u.Tags)
+	if err != nil {
+		return nil, nil, FieldErrors{"tags": err.Error()}
+	}
+	if old != nil && old.Locked {
+		return nil, nil, errUserLocked
+	}
+	if old != nil {
+		if expected != old.Version {
+			return nil, nil, errVersionMismatch
+		}
+		// lock and delete state are changed only via their own endpoints
+		u.Locked = old.Locked
+		u.Deleted = old.Deleted
+		u.Version = old.Version + 1
+	} else {
+		u.Deleted = false
+		u.Version = 1
+	}
+	setDerivedFields(u)
+	return old, u, nil
+}
+
+// writeUpdateError reports an error of prepareUpdate.
+func writeUpdateError(c echo.Context, err error) error {
+	var fields FieldErrors
+	switch {
+	case errors.As(err, &fields):
+		return writeValidationError(c, err)
+	case errors.Is(err, errUserNotFound):
+		return writeError(c, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, errUserLocked):
+		return writeError(c, http.StatusLocked, CodeLocked, err.Error())
+	case errors.Is(err, errVersionMismatch):
+		return writeError(c, http.StatusConflict, CodeConflict, err.Error())
+	}
+	return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+}
+
+// isUnchanged reports whether the update of old to u, which carries the next
+// version, changes nothing else.
+func isUnchanged(old, u *User) (bool, error) {
+	same := *u
+	same.Version = old.Version
+	_, update, err := extractDiffs(old, &same)
+	if err != nil {
+		return false, err
+	}
+	return len(update) == 0, nil
+}
+
 func updateUser(c echo.Context) error {
 	u := &User{}
 	err := c.Bind(u)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, err.Error())
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
 	}
 
-	old := users[u.ID]
-	users[u.ID] = u
-	fmt.Printf("updated user is: %v\n", u)
+	expected, err := expectedVersion(c, u.Version)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, u, err := s.prepareUpdate(u, expected)
+	if err != nil {
+		return writeUpdateError(c, err)
+	}
+	if old != nil && !forceEvent(c) {
+		unchanged, err := isUnchanged(old, u)
+		if err != nil {
+			return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		}
+		if unchanged {
+			getLogger(c).Info("user unchanged", "user_id", u.ID, "version", old.Version)
+			return c.JSON(http.StatusOK, unchangedUpdate)
+		}
+	}
+	s.users[u.ID] = u
+	getLogger(c).Info("user updated", "user_id", u.ID, "version", u.Version)
+	if skipAudit(c) {
+		err = s.storeState(u.ID, u)
+		if err != nil {
+			return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		}
+		rollback, update, err := extractRawDiffs(old, u)
+		if err != nil {
+			return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		}
+		return c.JSON(http.StatusOK, UserUpdate{Update: update, Rollback: rollback})
+	}
 
-	err = addEvent("admin", "some_user", "user_update", old, users[u.ID])
+	event, err := s.newEvent(initiator(c), subject(c), u.ID, "user_update", old, u)
+	if err != nil {
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+	event.Metadata = eventMetadata(c)
+	err = s.recordEvent(event, u)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, err.Error())
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
 	}
 
-	return c.JSON(http.StatusOK, "updated")
+	return c.JSON(http.StatusOK, UserUpdate{EventID: event.ID, Update: event.Update, Rollback: event.Rollback})
 }
 
-func getPatched(patchType string, eventID, entityID int64) (*User, error) {
-	u, err := getUser(int64(entityID))
+func createUser(c echo.Context) error {
+	u := &User{}
+	err := c.Bind(u)
 	if err != nil {
-		return nil, err
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
 	}
-	requiredEvents, err := getEvents(int64(eventID))
+
+	u, err = applyUserDefaults(u)
 	if err != nil {
-		return nil, err
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	u.Locked = false
+	u.Deleted = false
+	u.Version = 1
+	setDerivedFields(u)
+	err = validateTags(u.Tags)
+	if err != nil {
+		return writeError(c, http.StatusUnprocessableEntity, CodeInvalidInput, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u.ID == 0 {
+		u.ID = s.nextUserID()
+	}
+	err = validateUser(u)
+	if err != nil {
+		return writeValidationError(c, err)
+	}
+	if _, ok := s.users[u.ID]; ok {
+		return writeError(c, http.StatusConflict, CodeConflict, "user with this id already exist")
+	}
+
+	s.users[u.ID] = u
+	if skipAudit(c) {
+		err = s.storeState(u.ID, u)
+	} else {
+		err = s.addEvent(initiator(c), subject(c), u.ID, UserCreateAction, nil, u, eventMetadata(c))
+	}
+	if err != nil {
+		delete(s.users, u.ID)
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	getLogger(c).Info("user created", "user_id", u.ID)
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/user/%d", u.ID))
+	return c.JSON(http.StatusCreated, u)
+}
+
+func deleteUser(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.getUser(entityID)
+	if err != nil {
+		return writeError(c, http.StatusNotFound, CodeNotFound, err.Error())
+	}
+	if u.Locked {
+		return writeError(c, http.StatusLocked, CodeLocked, errUserLocked.Error())
+	}
+
+	deleted := *u
+	deleted.Deleted = true
+	deleted.Version++
+	s.users[u.ID] = &deleted
+	if skipAudit(c) {
+		err = s.storeState(u.ID, &deleted)
+		if err != nil {
+			s.users[u.ID] = u
+			return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+	err = s.addEvent(initiator(c), subject(c), u.ID, UserDeleteAction, u, &deleted, eventMetadata(c))
+	if err != nil {
+		s.users[u.ID] = u
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	getLogger(c).Info("user deleted", "user_id", u.ID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// dryRunEvent returns the event updateUser would record for the same
+// request, without recording it.
+func dryRunEvent(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	u := &User{}
+	err = c.Bind(u)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	if u.ID == 0 {
+		u.ID = entityID
+	}
+	if u.ID != entityID {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, "user id does not match the path")
+	}
+
+	expected, err := expectedVersion(c, u.Version)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	old, u, err := s.prepareUpdate(u, expected)
+	if err != nil {
+		return writeUpdateError(c, err)
+	}
+	if old != nil && !forceEvent(c) {
+		unchanged, err := isUnchanged(old, u)
+		if err != nil {
+			return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		}
+		if unchanged {
+			return c.JSON(http.StatusOK, unchangedUpdate)
+		}
+	}
+
+	event, err := s.newEvent(initiator(c), subject(c), u.ID, "user_update", old, u)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, event)
+}
+
+func bulkUpdateUsers(c echo.Context) error {
+	list := []*User{}
+	err := c.Bind(&list)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	audit := !skipAudit(c)
+	updated := 0
+	ops := make([]batchOp, len(list))
+	for i, u := range list {
+		u := u
+		var old *User
+		changed := false
+		ops[i] = batchOp{
+			check: func() error {
+				if u == nil || u.ID == 0 {
+					return errors.New("user id is required")
+				}
+				if err := validateUser(u); err != nil {
+					return err
+				}
+				if err := validateTags(u.Tags); err != nil {
+					return err
+				}
+				existing, err := s.getUser(u.ID)
+				if err != nil {
+					return err
+				}
+				if existing.Locked {
+					return errUserLocked
+				}
+				if u.Version != existing.Version {
+					return errVersionMismatch
+				}
+				return nil
+			},
+			apply: func() error {
+				old = s.users[u.ID]
+				u.Locked = old.Locked
+				u.Deleted = false
+				setDerivedFields(u)
+				if reflect.DeepEqual(old, u) {
+					return nil
+				}
+				u.Version = old.Version + 1
+				s.users[u.ID] = u
+				var err error
+				if audit {
+					err = s.addEvent(initiator(c), subject(c), u.ID, "user_update", old, u, eventMetadata(c))
+				} else {
+					err = s.storeState(u.ID, u)
+				}
+				if err != nil {
+					s.users[u.ID] = old
+					return err
+				}
+				changed = true
+				updated++
+				return nil
+			},
+			undo: func() {
+				if !changed {
+					return
+				}
+				s.users[u.ID] = old
+				updated--
+				if !audit {
+					s.storeState(u.ID, old)
+					return
+				}
+				last := s.events[len(s.events)-1]
+				s.events = s.events[:len(s.events)-1]
+				s.undoInBackend(last.ID, u.ID, old)
+			},
+		}
+	}
+
+	atomic := isAtomic(c)
+	if atomic {
+		// undo only takes the events back from memory and the backend
+		s.beginBatch()
+	}
+	results := runBatch(ops, atomic)
+	status := http.StatusMultiStatus
+	if atomic {
+		status = http.StatusOK
+		if batchFailed(results) {
+			s.abortBatch()
+			status = http.StatusUnprocessableEntity
+		} else if err := s.commitBatch(); err != nil {
+			for i := len(ops) - 1; i >= 0; i-- {
+				ops[i].undo()
+			}
+			return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		}
+	}
+
+	return c.JSON(status, &BulkUpdateResult{Updated: updated, Results: results})
+}
+
+// BulkUpdateResult sums up a bulk update. Updated counts the users that
+// changed; users sent unchanged record no event.
+type BulkUpdateResult struct {
+	Updated int               `json:"updated"`
+	Results []BatchItemResult `json:"results"`
+}
+
+type ReconstructRequest struct {
+	Base    *User             `json:"base"`
+	Patches []json.RawMessage `json:"patches"`
+}
+
+func reconstructUser(c echo.Context) error {
+	req := &ReconstructRequest{}
+	err := c.Bind(req)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	if req.Base == nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, "base user is required")
+	}
+
+	u, err := applyPatches(req.Base, req.Patches)
+	if err != nil {
+		return writeError(c, http.StatusUnprocessableEntity, CodeInvalidInput, err.Error())
 	}
 
-	serialized, err := json.Marshal(u)
+	return c.JSON(http.StatusOK, u)
+}
+
+// applyPatches applies the RFC 6902 patches to base one after another and
+// reports the index of the first patch that fails.
+func applyPatches(base *User, patches []json.RawMessage) (*User, error) {
+	source, err := json.Marshal(base)
 	if err != nil {
 		return nil, err
 	}
 
-	source := make([]byte, 0)
-	for i := len(requiredEvents) - 1; i >= 0; i-- {
-		if i == len(requiredEvents)-1 {
-			source = serialized
+	for i, raw := range patches {
+		p, err := jsonpatch.DecodePatch(raw)
+		if err != nil {
+			return nil, fmt.Errorf("patch %d: %w", i, err)
 		}
-		source, err = patch(requiredEvents[i], patchType, source)
+		err = checkPatchOps(p)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("patch %d: %w", i, err)
+		}
+		source, err = applyPatch(source, p)
+		if err != nil {
+			return nil, fmt.Errorf("patch %d: %w", i, err)
 		}
 	}
 
-	patched := &User{}
-	err = json.Unmarshal(source, patched)
+	u := &User{}
+	err = json.Unmarshal(source, u)
 	if err != nil {
 		return nil, err
 	}
 
-	return patched, nil
+	return u, nil
+}
+
+func lockUser(c echo.Context) error {
+	return setUserLock(c, true)
+}
+
+func unlockUser(c echo.Context) error {
+	return setUserLock(c, false)
+}
+
+func setUserLock(c echo.Context, locked bool) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.getUser(entityID)
+	if err != nil {
+		return writeError(c, http.StatusNotFound, CodeNotFound, err.Error())
+	}
+	if u.Locked == locked {
+		return c.JSON(http.StatusOK, u)
+	}
+
+	action := "user_unlock"
+	if locked {
+		action = "user_lock"
+	}
+
+	old := *u
+	updated := *u
+	updated.Locked = locked
+	updated.Version++
+	s.users[u.ID] = &updated
+
+	err = s.addEvent(initiator(c), subject(c), u.ID, action, &old, &updated, eventMetadata(c))
+	if err != nil {
+		s.users[u.ID] = u
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &updated)
+}
+
+func (s *Store) getPatched(patchType string, eventID, entityID int64) (*User, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key := patchedKey{patchType: patchType, eventID: eventID, entityID: entityID}
+	version := s.versions[entityID]
+	if cached, ok := s.patched.Get(key); ok && cached.version == version {
+		return cached.user, cached.replayed, nil
+	}
+
+	serialized, err := s.currentState(entityID)
+	if err != nil {
+		return nil, 0, err
+	}
+	patched, replayed, err := s.reconstructEntity(UserEntity, patchType, eventID, entityID, serialized)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	u := patched.(*User)
+
+	s.patched.Add(key, patchedEntry{version: version, user: u, replayed: replayed})
+	return u, replayed, nil
 }
 
 func patch(e *Event, patchType string, source []byte) ([]byte, error) {
@@ -281,7 +1363,7 @@ func patch(e *Event, patchType string, source []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	patchedAsBytes, err := applyPatch(source, p)
+	patchedAsBytes, err := verifyAndApply(source, p)
 	if err != nil {
 		return nil, err
 	}
@@ -289,31 +1371,38 @@ func patch(e *Event, patchType string, source []byte) ([]byte, error) {
 	return patchedAsBytes, nil
 }
 
-func getRequiredPatch(e *Event, patchType string) (interface{}, error) {
-	var requiredPatch interface{}
+// verifyAndApply applies p like applyPatch. A failed test operation means that
+// entity is not the state p was created from, and is reported as
+// errStateDrift.
+func verifyAndApply(entity []byte, p jsonpatch.Patch) ([]byte, error) {
+	patched, err := applyPatch(entity, p)
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return nil, fmt.Errorf("%w: %v", errStateDrift, err)
+	}
+	return patched, err
+}
+
+func getRequiredPatch(e *Event, patchType string) (json.RawMessage, error) {
+	var requiredPatch json.RawMessage
 	switch patchType {
 	case RollbackType:
 		requiredPatch = e.Rollback
 	case UpdateType:
 		requiredPatch = e.Update
 	default:
-		return nil, errors.New("wrong patch type")
+		return nil, errUnknownPatchType
 	}
 
 	return requiredPatch, nil
 }
 
-func convertToPatch(value interface{}) (jsonpatch.Patch, error) {
-	// serialized, err := json.Marshal(value)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	serialized, err := skipAndConvert(value)
+// convertToPatch decodes value, whatever its type, as an RFC 6902 patch from
+// its JSON form: a serialized patch, a jsondiff.Patch or a decoded one alike.
+func convertToPatch(value any) (jsonpatch.Patch, error) {
+	serialized, err := json.Marshal(value)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println(string(serialized))
 
 	patch, err := jsonpatch.DecodePatch(serialized)
 	if err != nil {
@@ -323,18 +1412,84 @@ func convertToPatch(value interface{}) (jsonpatch.Patch, error) {
 	return patch, nil
 }
 
-func getUser(id int64) (*User, error) {
-	if u, ok := users[id]; ok {
+func (s *Store) getUser(id int64) (*User, error) {
+	return s.lookupUser(id, false)
+}
+
+// lookupUser returns the user with the given id, treating deleted users as
+// missing unless includeDeleted is set.
+func (s *Store) lookupUser(id int64, includeDeleted bool) (*User, error) {
+	if u, ok := s.users[id]; ok && (includeDeleted || !u.Deleted) {
 		return u, nil
 	}
-	return nil, errors.New("user with this id not exist")
+	return nil, errUserNotFound
+}
+
+// currentState returns the serialized current state of a user. Users without
+// state are "null", which the rollback of the event removing them restores
+// from.
+func (s *Store) currentState(id int64) ([]byte, error) {
+	u, err := s.lookupUser(id, true)
+	if err == nil {
+		return json.Marshal(u)
+	}
+	// users with events but without state were removed by rolling back their
+	// creation, or deleted before deleteUser kept them
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if s.events[i].changes(UserEntity, id) {
+			return []byte("null"), nil
+		}
+	}
+	return nil, err
+}
+
+func (s *Store) nextUserID() int64 {
+	var max int64
+	for id := range s.users {
+		if id > max {
+			max = id
+		}
+	}
+	return max + 1
+}
+
+// nextEventID returns the ID of the next appended event. It is above every
+// event in the log and every one appended since the store was created, so IDs
+// of events removed from the log, e.g. by an aborted batch, are not reused.
+// The caller must hold s.mu.
+func (s *Store) nextEventID() int64 {
+	return s.lastEventID + 1
 }
 
-func getEvents(id int64) ([]*Event, error) {
-	if int(id) <= len(events)-1 {
-		return events[int(id):], nil
+// maxEventID returns the highest ID of events, 0 for none.
+func maxEventID(events []*Event) int64 {
+	var max int64
+	for _, e := range events {
+		if e.ID > max {
+			max = e.ID
+		}
 	}
-	return nil, errors.New("event with this id not exist")
+	return max
+}
+
+func (s *Store) getEvent(id int64) (*Event, error) {
+	for _, e := range s.events {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return nil, errEventNotFound
+}
+
+// getEvents returns the event with the given id and every event recorded
+// after it.
+func (s *Store) getEvents(id int64) ([]*Event, error) {
+	for i, e := range s.events {
+		if e.ID == id {
+			return s.events[i:], nil
+		}
+	}
+	return nil, errEventNotFound
 }
 
 func applyPatch(entity []byte, patch jsonpatch.Patch) ([]byte, error) {
@@ -343,32 +1498,116 @@ func applyPatch(entity []byte, patch jsonpatch.Patch) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	patched, err := p.Apply(entity)
+	patched, err := applyDocumentPatch(entity, p)
 	if err != nil {
 		return nil, err
 	}
 	return patched, err
 }
 
-func skipAndConvert(value interface{}) ([]byte, error) {
-	jdPatch, ok := value.(jsondiff.Patch)
-	if !ok {
-		return nil, errors.New("can't convert to jsonDIFF")
+// applyDocumentPatch applies p like p.Apply but also supports operations on
+// the whole document (path ""), which events of created and deleted entities
+// carry and the patch library rejects.
+func applyDocumentPatch(doc []byte, p jsonpatch.Patch) ([]byte, error) {
+	var err error
+	start := 0
+	for i, op := range p {
+		if path, _ := op.Path(); path != "" {
+			continue
+		}
+		if i > start {
+			doc, err = p[start:i].Apply(doc)
+			if err != nil {
+				return nil, err
+			}
+		}
+		doc, err = applyRootOp(doc, op)
+		if err != nil {
+			return nil, err
+		}
+		start = i + 1
+	}
+	if start == 0 {
+		return p.Apply(doc)
+	}
+	if start < len(p) {
+		return p[start:].Apply(doc)
+	}
+	return doc, nil
+}
+
+func applyRootOp(doc []byte, op jsonpatch.Operation) ([]byte, error) {
+	value := []byte("null")
+	if raw := op["value"]; raw != nil {
+		value = *raw
 	}
 
-	updatedPatch := make(jsondiff.Patch, 0)
-	for _, op := range jdPatch {
-		if op.Path[1:len("/bag")] == "bag" {
-			continue
+	switch op.Kind() {
+	case "add", "replace":
+		return value, nil
+	case "remove":
+		return []byte("null"), nil
+	case "test":
+		equal, err := jsonEqual(doc, value)
+		if err != nil {
+			return nil, err
 		}
+		if !equal {
+			return nil, errors.New("testing value failed for the whole document")
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%s operation is not supported on the whole document", op.Kind())
+	}
+}
 
-		updatedPatch = append(updatedPatch, op)
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
+	return keys
+}
 
-	serialized, err := json.Marshal(updatedPatch)
-	if err != nil {
-		return nil, err
+// parsePathList parses comma-separated JSON pointers.
+func parsePathList(list string) ([]string, error) {
+	paths := []string{}
+	for _, path := range strings.Split(list, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if err := validatePointer(path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
 	}
+	return paths, nil
+}
 
-	return serialized, nil
+func parseOpList(list string) map[string]bool {
+	ops := make(map[string]bool)
+	for _, op := range strings.Split(list, ",") {
+		if op = strings.TrimSpace(op); op != "" {
+			ops[op] = true
+		}
+	}
+	return ops
+}
+
+// checkPatchOps rejects operations missing from allowedPatchOps when strict
+// mode is on. Permissive mode accepts anything the patch library accepts.
+// Only the patches clients send are checked: the patches of events are
+// invertible diffs holding test operations, which rollbacks and replays
+// apply whatever the allowlist says.
+func checkPatchOps(p jsonpatch.Patch) error {
+	if !strictPatchOps {
+		return nil
+	}
+	for i, op := range p {
+		if !allowedPatchOps[op.Kind()] {
+			return fmt.Errorf("operation %d: %q is not allowed", i, op.Kind())
+		}
+	}
+	return nil
 }
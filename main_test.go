@@ -0,0 +1,812 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMain(m *testing.M) {
+	logLevel.Set(slog.LevelError)
+	os.Exit(m.Run())
+}
+
+// newTestServer serves the routes against s, without the optional
+// middleware main adds from flags.
+func newTestServer(s *Store) *echo.Echo {
+	e := echo.New()
+	e.JSONSerializer = timeJSONSerializer{}
+	e.Pre(requestID)
+	e.Use(withStore(s))
+	e.Use(checkTimeFormat)
+	registerRoutes(e)
+	return e
+}
+
+// request sends a request with a JSON body, when not empty, to e.
+func request(t testing.TB, e *echo.Echo, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// expectStatus fails t unless rec has the given status.
+func expectStatus(t testing.TB, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d, body %s", rec.Code, status, rec.Body.String())
+	}
+}
+
+func decodeBody[T any](t testing.TB, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+func TestBulkUpdateAtomic(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	rec := request(t, e, http.MethodPut, "/users/bulk", `[{"id":1,"name":"A","age":20,"version":1},{"id":1,"name":"B","age":20,"version":1}]`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	res := decodeBody[BulkUpdateResult](t, rec)
+	if res.Updated != 0 || res.Results[0].Status != http.StatusFailedDependency || res.Results[1].Status != http.StatusUnprocessableEntity {
+		t.Fatalf("result = %+v", res)
+	}
+	if s.users[1].Name != "John" || len(s.events) != 0 {
+		t.Fatalf("aborted batch changed the store: %+v, %d events", s.users[1], len(s.events))
+	}
+
+	rec = request(t, e, http.MethodPut, "/users/bulk", `[{"id":1,"name":"A","age":20,"version":1}]`)
+	expectStatus(t, rec, http.StatusOK)
+	if s.users[1].Name != "A" || len(s.events) != 1 {
+		t.Fatalf("batch not applied: %+v, %d events", s.users[1], len(s.events))
+	}
+}
+
+func TestBulkUpdateBestEffort(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	rec := request(t, e, http.MethodPut, "/users/bulk?atomic=false", `[{"id":1,"name":"A","age":20,"version":1},{"id":2,"name":"B"}]`)
+	expectStatus(t, rec, http.StatusMultiStatus)
+	res := decodeBody[BulkUpdateResult](t, rec)
+	if res.Updated != 1 || res.Results[0].Status != http.StatusOK || res.Results[1].Status != http.StatusUnprocessableEntity {
+		t.Fatalf("result = %+v", res)
+	}
+	if s.users[1].Name != "A" {
+		t.Fatalf("user 1 = %+v", s.users[1])
+	}
+}
+
+func TestLockedUserRejectsChanges(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	rec := request(t, e, http.MethodPost, "/user/1/lock", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); !u.Locked || u.Version != 2 {
+		t.Fatalf("locked user = %+v", u)
+	}
+	if len(s.events) != 1 || s.events[0].Action != "user_lock" {
+		t.Fatalf("events = %+v", s.events)
+	}
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":2}`), http.StatusLocked)
+	rec = request(t, e, http.MethodPut, "/users/bulk", `[{"id":1,"name":"A","age":20,"version":2}]`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	if res := decodeBody[BulkUpdateResult](t, rec); res.Results[0].Status != http.StatusUnprocessableEntity {
+		t.Fatalf("bulk result = %+v", res)
+	}
+	expectStatus(t, request(t, e, http.MethodDelete, "/user/1", ""), http.StatusLocked)
+	if u := s.users[1]; u == nil || u.Name != "John" || len(s.events) != 1 {
+		t.Fatalf("locked user changed: %+v, %d events", u, len(s.events))
+	}
+
+	// locking again changes nothing
+	expectStatus(t, request(t, e, http.MethodPost, "/user/1/lock", ""), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/user/1/unlock", ""), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":3}`), http.StatusOK)
+	if len(s.events) != 3 || s.events[1].Action != "user_unlock" {
+		t.Fatalf("events = %+v", s.events)
+	}
+}
+
+func TestEventsListExcludeReverted(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":16,"version":2}`), http.StatusOK)
+	if err := s.markReverted(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.markReverted(3, 2); err == nil {
+		t.Fatal("marked an unknown event")
+	}
+
+	for query, want := range map[string]int{"": 2, "&exclude_reverted=true": 1} {
+		rec := request(t, e, http.MethodGet, "/events?created_at=2023-01-01T00:00:00Z"+query, "")
+		expectStatus(t, rec, http.StatusOK)
+		list := decodeBody[EventsList](t, rec).Events
+		if len(list) != want || list[len(list)-1].ID != 2 {
+			t.Errorf("%q: events = %+v, want %d ending with event 2", query, list, want)
+		}
+	}
+}
+
+func TestReconstructUser(t *testing.T) {
+	e := newTestServer(NewStore())
+
+	rec := request(t, e, http.MethodPost, "/reconstruct", `{"base":{"id":1,"name":"John","age":16},"patches":[[{"op":"replace","path":"/name","value":"A"}],[{"op":"add","path":"/bag","value":{"phone":"Pixel"}}]]}`)
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "A" || u.Bag == nil || u.Bag.Phone != "Pixel" {
+		t.Fatalf("reconstructed user = %+v", u)
+	}
+
+	rec = request(t, e, http.MethodPost, "/reconstruct", `{"base":{"id":1},"patches":[[{"op":"remove","path":"/name"}]]}`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	if apiErr := decodeBody[APIError](t, rec); !strings.HasPrefix(apiErr.Message, "patch 0: ") {
+		t.Fatalf("message = %q", apiErr.Message)
+	}
+	expectStatus(t, request(t, e, http.MethodPost, "/reconstruct", `{"patches":[]}`), http.StatusBadRequest)
+}
+
+func TestStrictPatchOps(t *testing.T) {
+	strictPatchOps = true
+	allowedPatchOps = parseOpList("add,remove,replace")
+	t.Cleanup(func() {
+		strictPatchOps = false
+		allowedPatchOps = parseOpList("add,remove,replace,test")
+	})
+	e := newTestServer(newSeededStore())
+
+	rec := request(t, e, http.MethodPatch, "/user/1", `[{"op":"copy","from":"/name","path":"/bag/food"}]`)
+	if rec.Code < 400 {
+		t.Fatalf("copy in strict mode: status %d", rec.Code)
+	}
+	if apiErr := decodeBody[APIError](t, rec); apiErr.Message != `operation 0: "copy" is not allowed` {
+		t.Fatalf("message = %q", apiErr.Message)
+	}
+	expectStatus(t, request(t, e, http.MethodPatch, "/user/1", `[{"op":"replace","path":"/name","value":"A"}]`), http.StatusOK)
+	rec = request(t, e, http.MethodPost, "/reconstruct", `{"base":{"id":1,"name":"A"},"patches":[[{"op":"test","path":"/name","value":"A"}]]}`)
+	if apiErr := decodeBody[APIError](t, rec); rec.Code != http.StatusUnprocessableEntity || apiErr.Message != `patch 0: operation 0: "test" is not allowed` {
+		t.Fatalf("test op sent in strict mode: %d %+v", rec.Code, apiErr)
+	}
+
+	// the test operations of the event patches are not sent by clients
+	rec = request(t, e, http.MethodGet, "/patch/rollback/1/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "John" {
+		t.Fatalf("rolled back to %+v", u)
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1/forward/1", ""), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/events/1/rollback", ""), http.StatusOK)
+
+	strictPatchOps = false
+	expectStatus(t, request(t, e, http.MethodPatch, "/user/1", `[{"op":"copy","from":"/name","path":"/bag/food"}]`), http.StatusOK)
+}
+
+func TestEventsListAsJSONPatch(t *testing.T) {
+	s := newSeededStore()
+	original, _ := json.Marshal(s.users[1])
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"B","age":21,"version":2}`), http.StatusOK)
+
+	rec := request(t, e, http.MethodGet, "/events?created_at=2023-01-01T00:00:00Z&as=jsonpatch", "")
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != MIMEApplicationPatch {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	p, err := jsonpatch.DecodePatch(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("body %s is not a JSON Patch: %v", rec.Body.String(), err)
+	}
+	patched, err := p.Apply(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, _ := json.Marshal(s.users[1])
+	if equal, err := jsonEqual(patched, current); err != nil || !equal {
+		t.Fatalf("patched seed = %s, want %s", patched, current)
+	}
+}
+
+func TestGetPatched(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"B","age":16,"version":2}`), http.StatusOK)
+
+	rec := request(t, e, http.MethodGet, "/patch/rollback/2/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "A" {
+		t.Fatalf("rolled back to 2 = %+v", u)
+	}
+	rec = request(t, e, http.MethodGet, "/patch/rollback/1/1?meta=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	resp := decodeBody[PatchedResponse](t, rec)
+	if resp.User.Name != "John" || resp.EventsReplayed != 2 || resp.PatchType != RollbackType || resp.EventID != 1 || resp.Diff != nil {
+		t.Fatalf("meta response = %+v", resp)
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/patch/rollback/3/1", ""), http.StatusNotFound)
+}
+
+func TestDryRunEventMatchesUpdate(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	body := `{"id":1,"name":"A","age":20,"bag":{"phone":"Poco F3"},"version":1}`
+
+	rec := request(t, e, http.MethodPost, "/user/1/dry-event", body)
+	expectStatus(t, rec, http.StatusOK)
+	dry := decodeBody[Event](t, rec)
+	if len(s.events) != 0 || s.users[1].Name != "John" {
+		t.Fatal("dry run changed the store")
+	}
+
+	rec = request(t, e, http.MethodPut, "/user/update/1", body)
+	expectStatus(t, rec, http.StatusOK)
+	recorded := s.events[0]
+	if string(dry.Update) != string(recorded.Update) || string(dry.Rollback) != string(recorded.Rollback) {
+		t.Fatalf("dry run patches\n%s\n%s\ndiffer from the recorded ones\n%s\n%s", dry.Update, dry.Rollback, recorded.Update, recorded.Rollback)
+	}
+
+	expectStatus(t, request(t, e, http.MethodPost, "/user/1/dry-event", body), http.StatusConflict)
+	expectStatus(t, request(t, e, http.MethodPost, "/user/1/dry-event", `{"id":1,"age":20,"version":2}`), http.StatusUnprocessableEntity)
+}
+
+func TestBagRoundTrips(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Ann","age":30}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Ann","age":30,"bag":{"phone":"Poco F3"},"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Ann","age":30,"version":2}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Ann","age":30,"bag":{},"version":3}`), http.StatusOK)
+
+	tests := []struct {
+		target string
+		bag    *Backpack
+	}{
+		// rolling back the removal restores the bag, rolling back its
+		// addition takes it away again
+		{"/patch/rollback/3/2", &Backpack{Phone: "Poco F3"}},
+		{"/patch/rollback/2/2", nil},
+		// an empty bag stays distinct from no bag
+		{"/patch/rollback/4/2", nil},
+		{"/user/2/forward/2", &Backpack{Phone: "Poco F3"}},
+		{"/user/2/forward/3", nil},
+		{"/user/2/forward/4", &Backpack{}},
+	}
+	for _, tt := range tests {
+		rec := request(t, e, http.MethodGet, tt.target, "")
+		expectStatus(t, rec, http.StatusOK)
+		u := decodeBody[User](t, rec)
+		if (u.Bag == nil) != (tt.bag == nil) || (u.Bag != nil && *u.Bag != *tt.bag) {
+			t.Errorf("%s: bag = %+v, want %+v", tt.target, u.Bag, tt.bag)
+		}
+	}
+}
+
+func TestParsePathList(t *testing.T) {
+	paths, err := parsePathList(" /bag/phone, ,/tags ")
+	if err != nil || len(paths) != 2 || paths[0] != "/bag/phone" || paths[1] != "/tags" {
+		t.Fatalf("paths = %q, %v", paths, err)
+	}
+	for _, list := range []string{"bag", "/bag,/a~2"} {
+		if _, err := parsePathList(list); err == nil {
+			t.Errorf("%q: want an invalid pointer error", list)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	e := newTestServer(NewStore())
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-01-02T03:04:05Z", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"2024-01-02T03:04:05%2B02:00", time.Date(2024, 1, 2, 1, 4, 5, 0, time.UTC)},
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-02T03:04:05", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		rec := request(t, e, http.MethodGet, "/parse_date?created_at="+tt.value, "")
+		expectStatus(t, rec, http.StatusOK)
+		if got := decodeBody[time.Time](t, rec); !got.Equal(tt.want) {
+			t.Errorf("%s: date = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	rec := request(t, e, http.MethodGet, "/parse_date?created_at=02.01.2024", "")
+	expectStatus(t, rec, http.StatusBadRequest)
+	if apiErr := decodeBody[APIError](t, rec); apiErr.Message != errDateLayout.Error() {
+		t.Errorf("message = %q, want the accepted layouts", apiErr.Message)
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/parse_date", ""), http.StatusBadRequest)
+}
+
+func TestCreateUser(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	rec := request(t, e, http.MethodPost, "/user", `{"name":"Ann","age":30}`)
+	expectStatus(t, rec, http.StatusCreated)
+	u := decodeBody[User](t, rec)
+	if u.ID != 2 || !u.IsAdult || u.Version != 1 {
+		t.Fatalf("created user = %+v, want id 2, adult, version 1", u)
+	}
+	if loc := rec.Header().Get(echo.HeaderLocation); loc != "/user/2" {
+		t.Fatalf("Location = %q", loc)
+	}
+	if len(s.events) != 1 || s.events[0].Action != UserCreateAction || s.events[0].EntityID != 2 {
+		t.Fatalf("events = %+v", s.events)
+	}
+
+	rec = request(t, e, http.MethodPost, "/user", `{"id":2,"name":"Bob","age":30}`)
+	expectStatus(t, rec, http.StatusConflict)
+	if s.users[2].Name != "Ann" || len(s.events) != 1 {
+		t.Fatal("duplicate create changed the store")
+	}
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"age":30}`), http.StatusUnprocessableEntity)
+}
+
+func TestDeleteUser(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	expectStatus(t, request(t, e, http.MethodDelete, "/user/1", ""), http.StatusNoContent)
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1", ""), http.StatusNotFound)
+	expectStatus(t, request(t, e, http.MethodDelete, "/user/1", ""), http.StatusNotFound)
+	if len(s.events) != 1 || s.events[0].Action != UserDeleteAction {
+		t.Fatalf("events = %+v", s.events)
+	}
+
+	rec := request(t, e, http.MethodGet, "/user/1?include_deleted=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); !u.Deleted {
+		t.Fatalf("deleted user = %+v", u)
+	}
+
+	// the rollback of the deletion restores the user
+	rec = request(t, e, http.MethodGet, "/patch/rollback/1/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Deleted || u.Name != "John" || u.Bag == nil || u.Bag.Gun != "Beretta" {
+		t.Fatalf("restored user = %+v", u)
+	}
+	expectStatus(t, request(t, e, http.MethodDelete, "/user/x", ""), http.StatusBadRequest)
+}
+
+func TestUpdateSetsIsAdult(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	rec := request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":18,"version":1}`)
+	expectStatus(t, rec, http.StatusOK)
+	if !s.users[1].IsAdult {
+		t.Fatal("user of 18 is not adult")
+	}
+	res := decodeBody[UserUpdate](t, rec)
+	if !strings.Contains(string(res.Update), `"path":"/is_adult","value":true`) {
+		t.Fatalf("update %s does not set is_adult", res.Update)
+	}
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":17,"is_adult":true,"version":2}`), http.StatusOK)
+	if s.users[1].IsAdult {
+		t.Fatal("user of 17 is adult")
+	}
+}
+
+func TestBagChangesRollBack(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":16,"bag":{"phone":"Pixel","food":"Big tasty","gun":"Beretta"},"version":1}`), http.StatusOK)
+	if !strings.Contains(string(s.events[0].Update), `"/bag/phone"`) {
+		t.Fatalf("update %s does not change /bag/phone", s.events[0].Update)
+	}
+	rec := request(t, e, http.MethodGet, "/patch/rollback/1/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Bag == nil || u.Bag.Phone != "Poco F3" {
+		t.Fatalf("rolled back user = %+v", u)
+	}
+}
+
+func TestSkipPaths(t *testing.T) {
+	skipPaths = []string{"/bag"}
+	t.Cleanup(func() { skipPaths = []string{} })
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"Jo","age":16,"bag":{"phone":"Pixel"},"version":1}`), http.StatusOK)
+	if strings.Contains(string(s.events[0].Update), "/bag") || !strings.Contains(string(s.events[0].Update), "/name") {
+		t.Fatalf("update = %s, want the /name change only", s.events[0].Update)
+	}
+}
+
+func TestGetEventsByID(t *testing.T) {
+	s := NewStore()
+	for _, id := range []int64{2, 5, 9} {
+		s.events = append(s.events, &Event{ID: id})
+	}
+
+	events, err := s.getEvents(5)
+	if err != nil || len(events) != 2 || events[0].ID != 5 || events[1].ID != 9 {
+		t.Fatalf("events from 5 = %+v, %v", events, err)
+	}
+	if _, err := s.getEvents(3); !errors.Is(err, errEventNotFound) {
+		t.Fatalf("events from 3: err = %v, want %v", err, errEventNotFound)
+	}
+}
+
+func TestEventTimestamps(t *testing.T) {
+	s := newSeededStore()
+	fakeClock(s, clockStart)
+	e := newTestServer(s)
+	seedHistory(t, e)
+
+	for i, ev := range s.events {
+		if want := clockStart.Add(time.Duration(i+1) * time.Minute); !ev.CreatedAt.Equal(want) {
+			t.Errorf("event %d created at %v, want %v", ev.ID, ev.CreatedAt, want)
+		}
+	}
+}
+
+func TestEventIDsAreNotReused(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`), http.StatusOK)
+
+	// undoing a batch takes its events back out of the log
+	s.events = s.events[:0]
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"B","age":20,"version":2}`), http.StatusOK)
+	if len(s.events) != 1 || s.events[0].ID != 2 {
+		t.Fatalf("events = %+v, want only event 2", s.events)
+	}
+}
+
+func TestEventsListFilters(t *testing.T) {
+	s := newSeededStore()
+	fakeClock(s, clockStart)
+	e := newTestServer(s)
+	seedHistory(t, e)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"Jo","age":16,"version":1}`, HeaderSubject, "john"), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/events/4/rollback", "", HeaderSubject, "support"), http.StatusOK)
+
+	tests := []struct {
+		query string
+		ids   []int64
+	}{
+		{"", []int64{1, 2, 3, 4, 5}},
+		{"created_at=2024-01-01T00:02:00Z", []int64{2, 3, 4, 5}},
+		{"created_from=2024-01-01T00:02:00Z&created_to=2024-01-01T00:03:00Z", []int64{2, 3}},
+		{"created_to=2024-01-01T00:01:00Z", []int64{1}},
+		{"created_from=2024-01-01T00:03:30Z", []int64{4, 5}},
+		{"action=user_update", []int64{2, 3, 4}},
+		{"action=user_update&created_to=2024-01-01T00:02:00Z", []int64{2}},
+		{"initiator=admin&action=user_update", []int64{2, 3, 4}},
+		{"initiator=someone", nil},
+		{"subject=john", []int64{4}},
+		{"subject=support", []int64{5}},
+		{"subject=john&action=user_rollback", nil},
+		{"subject=nobody", nil},
+	}
+	for _, tt := range tests {
+		rec := request(t, e, http.MethodGet, "/events?"+tt.query, "")
+		expectStatus(t, rec, http.StatusOK)
+		list := decodeBody[EventsList](t, rec)
+		ids := []int64{}
+		for _, ev := range list.Events {
+			ids = append(ids, ev.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(append([]int64{}, tt.ids...)) || list.Total != len(tt.ids) {
+			t.Errorf("%q: events %v, total %d, want %v", tt.query, ids, list.Total, tt.ids)
+		}
+	}
+
+	for _, query := range []string{"created_at=yesterday", "created_from=2024-01-02&created_to=2024-01-01"} {
+		expectStatus(t, request(t, e, http.MethodGet, "/events?"+query, ""), http.StatusBadRequest)
+	}
+}
+
+func TestEventsListPagination(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	for i := int64(1); i <= 5; i++ {
+		expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", fmt.Sprintf(`{"id":1,"name":"N%d","age":20,"version":%d}`, i, i)), http.StatusOK)
+	}
+
+	rec := request(t, e, http.MethodGet, "/events", "")
+	expectStatus(t, rec, http.StatusOK)
+	if page := decodeBody[EventsList](t, rec); page.Limit != defaultPageLimit || page.Offset != 0 || page.Total != 5 || len(page.Events) != 5 {
+		t.Fatalf("default page = %+v", page)
+	}
+
+	rec = request(t, e, http.MethodGet, "/events?limit=2&offset=1", "")
+	expectStatus(t, rec, http.StatusOK)
+	page := decodeBody[EventsList](t, rec)
+	if page.Total != 5 || len(page.Events) != 2 || page.Events[0].ID != 2 || page.NextAfterID != 3 {
+		t.Fatalf("page = %+v", page)
+	}
+	rec = request(t, e, http.MethodGet, "/events?limit=2&after_id=3", "")
+	expectStatus(t, rec, http.StatusOK)
+	if page := decodeBody[EventsList](t, rec); page.Total != 2 || page.Events[0].ID != 4 || page.NextAfterID != 0 {
+		t.Fatalf("page after 3 = %+v", page)
+	}
+
+	rec = request(t, e, http.MethodGet, "/events?limit=100000", "")
+	expectStatus(t, rec, http.StatusOK)
+	if page := decodeBody[EventsList](t, rec); page.Limit != maxPageLimit {
+		t.Fatalf("limit = %d, want it clamped to %d", page.Limit, maxPageLimit)
+	}
+	rec = request(t, e, http.MethodGet, "/events?offset=10", "")
+	expectStatus(t, rec, http.StatusOK)
+	if page := decodeBody[EventsList](t, rec); page.Total != 5 || len(page.Events) != 0 {
+		t.Fatalf("page past the end = %+v", page)
+	}
+
+	for _, query := range []string{"limit=0", "offset=-1", "after_id=x", "offset=1&after_id=1"} {
+		expectStatus(t, request(t, e, http.MethodGet, "/events?"+query, ""), http.StatusBadRequest)
+	}
+}
+
+func TestGetEventByID(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+
+	rec := request(t, e, http.MethodGet, "/event/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	ev := decodeBody[Event](t, rec)
+	if ev.ID != 1 || ev.Update == nil || ev.Rollback == nil {
+		t.Fatalf("event = %+v", ev)
+	}
+
+	rec = request(t, e, http.MethodGet, "/event/1?as=jsonpatch", "")
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != MIMEApplicationPatch {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if _, err := jsonpatch.DecodePatch(rec.Body.Bytes()); err != nil {
+		t.Fatalf("body %s is not a JSON Patch: %v", rec.Body.String(), err)
+	}
+
+	expectStatus(t, request(t, e, http.MethodGet, "/event/2", ""), http.StatusNotFound)
+}
+
+func TestGetUserByID(t *testing.T) {
+	e := newTestServer(newSeededStore())
+
+	rec := request(t, e, http.MethodGet, "/user/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "John" {
+		t.Fatalf("user = %+v", u)
+	}
+	rec = request(t, e, http.MethodGet, "/user/2", "")
+	expectStatus(t, rec, http.StatusNotFound)
+	if apiErr := decodeBody[APIError](t, rec); apiErr.Code != CodeNotFound {
+		t.Fatalf("error = %+v", apiErr)
+	}
+}
+
+func TestPathParamsAreInt64(t *testing.T) {
+	e := newTestServer(newSeededStore())
+
+	for _, target := range []string{"/user/x", "/user/99999999999999999999", "/event/1.5", "/patch/update/x/1", "/patch/update/1/99999999999999999999"} {
+		rec := request(t, e, http.MethodGet, target, "")
+		expectStatus(t, rec, http.StatusBadRequest)
+		if apiErr := decodeBody[APIError](t, rec); !strings.HasSuffix(apiErr.Message, "must be a 64-bit integer") {
+			t.Errorf("%s: message = %q", target, apiErr.Message)
+		}
+	}
+	// beyond 32 bits but within 64
+	expectStatus(t, request(t, e, http.MethodGet, "/user/4294967297", ""), http.StatusNotFound)
+}
+
+func TestUnknownPatchType(t *testing.T) {
+	e := newTestServer(newSeededStore())
+
+	rec := request(t, e, http.MethodGet, "/patch/bogus/1/1", "")
+	expectStatus(t, rec, http.StatusBadRequest)
+	if apiErr := decodeBody[APIError](t, rec); apiErr.Message != "patch type must be one of rollback, update" {
+		t.Fatalf("message = %q", apiErr.Message)
+	}
+}
+
+func TestGetPatchedPreview(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"bag":{"phone":"Poco F3","food":"Big tasty","gun":"Beretta"},"version":1}`), http.StatusOK)
+
+	rec := request(t, e, http.MethodGet, "/patch/rollback/1/1?preview=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	resp := decodeBody[PatchedResponse](t, rec)
+	if resp.User.Name != "John" {
+		t.Fatalf("previewed user = %+v", resp.User)
+	}
+	// going back from the current user undoes the update
+	_, want, err := extractDiffs(s.users[1], resp.User)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(resp.Diff)
+	if wantJSON, _ := json.Marshal(want); string(got) != string(wantJSON) || !strings.Contains(string(got), `"value":"John"`) {
+		t.Fatalf("diff = %s, want %s", got, wantJSON)
+	}
+	if s.users[1].Name != "A" || len(s.events) != 1 {
+		t.Fatal("preview changed the store")
+	}
+}
+
+func TestGetPatchedEdgeCases(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	current := *s.users[1]
+	// a single event with empty patches
+	s.events = append(s.events, &Event{ID: 1, EntityID: 1, EntityType: UserEntity, Update: json.RawMessage("[]"), Rollback: json.RawMessage("[]")})
+	s.lastEventID = 1
+	for _, patchType := range []string{RollbackType, UpdateType} {
+		rec := request(t, e, http.MethodGet, "/patch/"+patchType+"/1/1", "")
+		expectStatus(t, rec, http.StatusOK)
+		u := decodeBody[User](t, rec)
+		if u.Name != current.Name || u.Bag == nil || *u.Bag != *current.Bag {
+			t.Errorf("%s of an empty patch = %+v, want %+v", patchType, u, current)
+		}
+	}
+
+	// no event of the user after the given one
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Ann","age":30}`), http.StatusOK)
+	rec := request(t, e, http.MethodGet, "/patch/rollback/2/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "John" {
+		t.Fatalf("user 1 without events in the chain = %+v", u)
+	}
+}
+
+func TestGetPatchedReportsStateDrift(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+
+	// changed behind the event log
+	s.mu.Lock()
+	s.users[1] = &User{ID: 1, Name: "Z", Age: 16, Version: 2}
+	s.bumpVersion(1)
+	s.mu.Unlock()
+
+	rec := request(t, e, http.MethodGet, "/patch/rollback/1/1", "")
+	expectStatus(t, rec, http.StatusConflict)
+	if apiErr := decodeBody[APIError](t, rec); apiErr.Code != CodeConflict || !strings.HasPrefix(apiErr.Message, errStateDrift.Error()) {
+		t.Fatalf("error = %+v", apiErr)
+	}
+}
+
+func TestConvertToPatch(t *testing.T) {
+	live, _, err := extractDiffs(&User{ID: 1, Name: "A"}, &User{ID: 1, Name: "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized, _ := json.Marshal(live)
+	decoded := []map[string]any{}
+	if err := json.Unmarshal(serialized, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range map[string]any{"live": live, "raw": json.RawMessage(serialized), "decoded": decoded} {
+		p, err := convertToPatch(value)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		patched, err := p.Apply([]byte(`{"id":1,"name":"B"}`))
+		if err != nil || string(patched) != `{"id":1,"name":"A"}` {
+			t.Errorf("%s: patched = %s, %v", name, patched, err)
+		}
+	}
+	if _, err := convertToPatch("not a patch"); err == nil {
+		t.Error("a string converted to a patch")
+	}
+}
+
+func TestUpdateValidatesUser(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	tests := []struct {
+		body  string
+		field string
+	}{
+		{`{"id":1,"name":"A","age":-1,"version":1}`, "age"},
+		{`{"id":1,"name":"A","age":151,"version":1}`, "age"},
+		{`{"id":1,"age":20,"version":1}`, "name"},
+		{`{"id":-2,"name":"A","age":20}`, "id"},
+		{`{"id":1,"name":"A","tags":["a","a"],"version":1}`, "tags"},
+	}
+	for _, tt := range tests {
+		rec := request(t, e, http.MethodPut, "/user/update/1", tt.body)
+		expectStatus(t, rec, http.StatusUnprocessableEntity)
+		if apiErr := decodeBody[APIError](t, rec); apiErr.Fields[tt.field] == "" {
+			t.Errorf("%s: fields = %v, want %s", tt.body, apiErr.Fields, tt.field)
+		}
+	}
+	if len(s.events) != 0 || s.users[1].Name != "John" {
+		t.Fatal("invalid updates changed the store")
+	}
+	// an omitted age is 0, which is valid
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","version":1}`), http.StatusOK)
+}
+
+func TestUpdateVersion(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`), http.StatusOK)
+	rec := request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"B","age":20,"version":1}`)
+	expectStatus(t, rec, http.StatusConflict)
+	if s.users[1].Name != "A" || s.users[1].Version != 2 {
+		t.Fatalf("stale update changed the user: %+v", s.users[1])
+	}
+
+	// the header overrides the version in the body
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"B","age":20,"version":1}`, HeaderExpectedVersion, "2"), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"C","age":20}`, HeaderExpectedVersion, "x"), http.StatusBadRequest)
+	if s.users[1].Version != 3 {
+		t.Fatalf("version = %d, want 3", s.users[1].Version)
+	}
+}
+
+func TestUpdateResponse(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	rec := request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":16,"bag":{"phone":"Poco F3"},"version":1}`)
+	expectStatus(t, rec, http.StatusOK)
+	res := decodeBody[UserUpdate](t, rec)
+	if res.EventID != 1 || res.Unchanged || string(res.Update) == "[]" || string(res.Rollback) == "[]" {
+		t.Fatalf("update = %+v", res)
+	}
+	if string(res.Update) != string(s.events[0].Update) {
+		t.Fatalf("update %s differs from the recorded %s", res.Update, s.events[0].Update)
+	}
+
+	// a no-op update records no event unless forced
+	body := `{"id":1,"name":"John","age":16,"bag":{"phone":"Poco F3"},"version":2}`
+	rec = request(t, e, http.MethodPut, "/user/update/1", body)
+	expectStatus(t, rec, http.StatusOK)
+	if res := decodeBody[UserUpdate](t, rec); !res.Unchanged || res.EventID != 0 || string(res.Update) != "[]" {
+		t.Fatalf("no-op update = %+v", res)
+	}
+	if len(s.events) != 1 || s.users[1].Version != 2 {
+		t.Fatalf("no-op update recorded: %d events, version %d", len(s.events), s.users[1].Version)
+	}
+	rec = request(t, e, http.MethodPut, "/user/update/1?force_event=true", body)
+	expectStatus(t, rec, http.StatusOK)
+	if res := decodeBody[UserUpdate](t, rec); res.Unchanged || res.EventID != 2 {
+		t.Fatalf("forced update = %+v", res)
+	}
+	if len(s.events) != 2 || s.users[1].Version != 3 {
+		t.Fatalf("forced update: %d events, version %d", len(s.events), s.users[1].Version)
+	}
+}
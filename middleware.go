@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultMaxURLLength = 8192
+	defaultMaxBodySize  = 1 << 20
+	defaultRate         = 100
+
+	requestIDContextKey = "request_id"
+)
+
+// maxURLLength rejects requests whose URL, path and query included, is longer
+// than limit bytes.
+func maxURLLength(limit int) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if len(c.Request().RequestURI) > limit || len(c.Request().URL.String()) > limit {
+				return writeError(c, http.StatusRequestURITooLong, CodeTooLarge, "uri too long")
+			}
+			return next(c)
+		}
+	}
+}
+
+// maxBodySize rejects requests whose body is longer than limit bytes with a
+// 413. Bodies without a Content-Length are read up front, so that handlers
+// never see a body cut at the limit.
+func maxBodySize(limit int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.ContentLength > limit {
+				return writeError(c, http.StatusRequestEntityTooLarge, CodeTooLarge, "request body too large")
+			}
+			if req.ContentLength < 0 {
+				body, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+				if err != nil {
+					return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+				}
+				if int64(len(body)) > limit {
+					return writeError(c, http.StatusRequestEntityTooLarge, CodeTooLarge, "request body too large")
+				}
+				req.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			return next(c)
+		}
+	}
+}
+
+// requestID passes the X-Request-ID of the request, or a new UUID when it has
+// none, on to the response and to handlers through getRequestID.
+func requestID(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Request().Header.Get(echo.HeaderXRequestID)
+		if id == "" {
+			id = uuid.NewString()
+		}
+		c.Response().Header().Set(echo.HeaderXRequestID, id)
+		c.Set(requestIDContextKey, id)
+		return next(c)
+	}
+}
+
+func getRequestID(c echo.Context) string {
+	id, _ := c.Get(requestIDContextKey).(string)
+	return id
+}
+
+// cors allows cross-origin calls from the comma-separated origins, "*" for
+// any, with the methods the routes use.
+func cors(origins string) echo.MiddlewareFunc {
+	allowed := []string{}
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed = append(allowed, origin)
+		}
+	}
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins: allowed,
+		AllowMethods: []string{http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete},
+	})
+}
+
+// rateLimit allows every client IP perSecond requests per second, in bursts
+// of as many, and answers the ones over the limit with a 429 carrying a
+// Retry-After header.
+func rateLimit(perSecond float64) echo.MiddlewareFunc {
+	burst := int(math.Ceil(perSecond))
+	retryAfter := strconv.Itoa(int(math.Ceil(1 / perSecond)))
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:  rate.Limit(perSecond),
+			Burst: burst,
+		}),
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			c.Response().Header().Set(echo.HeaderRetryAfter, retryAfter)
+			return writeError(c, http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded")
+		},
+	})
+}
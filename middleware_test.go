@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func TestRateLimitIgnoresForwardedFor(t *testing.T) {
+	e := newTestServer(newSeededStore())
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.Use(rateLimit(1))
+
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1", "", echo.HeaderXForwardedFor, "10.0.0.1"), http.StatusOK)
+	rec := request(t, e, http.MethodGet, "/user/1", "", echo.HeaderXForwardedFor, "10.0.0.2")
+	expectStatus(t, rec, http.StatusTooManyRequests)
+	if rec.Header().Get(echo.HeaderRetryAfter) != "1" {
+		t.Fatalf("Retry-After = %q", rec.Header().Get(echo.HeaderRetryAfter))
+	}
+}
+
+func TestMaxURLLength(t *testing.T) {
+	e := newTestServer(newSeededStore())
+	e.Pre(maxURLLength(32))
+
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1?fields=name", ""), http.StatusOK)
+	rec := request(t, e, http.MethodGet, "/user/1?fields=name,age,bag,tags,id", "")
+	expectStatus(t, rec, http.StatusRequestURITooLong)
+	if apiErr := decodeBody[APIError](t, rec); apiErr.Code != CodeTooLarge {
+		t.Fatalf("error = %+v", apiErr)
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	e.Pre(maxBodySize(64))
+
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"name":"Ann","age":30}`), http.StatusCreated)
+	rec := request(t, e, http.MethodPost, "/user", `{"name":"`+strings.Repeat("a", 64)+`"}`)
+	expectStatus(t, rec, http.StatusRequestEntityTooLarge)
+
+	// bodies of unknown length are checked as they are read
+	req := httptest.NewRequest(http.MethodPost, "/user", io.MultiReader(strings.NewReader(`{"name":"`), strings.NewReader(strings.Repeat("a", 64)+`"}`)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	expectStatus(t, rec, http.StatusRequestEntityTooLarge)
+	if len(s.users) != 2 {
+		t.Fatalf("%d users, want 2", len(s.users))
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	e := newTestServer(newSeededStore())
+
+	rec := request(t, e, http.MethodGet, "/user/2", "")
+	id := rec.Header().Get(echo.HeaderXRequestID)
+	if _, err := uuid.Parse(id); err != nil {
+		t.Fatalf("generated request id %q: %v", id, err)
+	}
+	if apiErr := decodeBody[APIError](t, rec); apiErr.RequestID != id {
+		t.Fatalf("error request id = %q, want %q", apiErr.RequestID, id)
+	}
+
+	rec = request(t, e, http.MethodGet, "/user/1", "", echo.HeaderXRequestID, "abc")
+	if got := rec.Header().Get(echo.HeaderXRequestID); got != "abc" {
+		t.Fatalf("request id = %q, want the client one", got)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	e := newTestServer(newSeededStore())
+	e.Use(cors("https://a.example, https://b.example"))
+
+	rec := request(t, e, http.MethodOptions, "/user/1", "", echo.HeaderOrigin, "https://b.example", echo.HeaderAccessControlRequestMethod, http.MethodPatch)
+	expectStatus(t, rec, http.StatusNoContent)
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != "https://b.example" {
+		t.Fatalf("allowed origin = %q", got)
+	}
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowMethods); !strings.Contains(got, http.MethodPatch) {
+		t.Fatalf("allowed methods = %q", got)
+	}
+
+	rec = request(t, e, http.MethodGet, "/user/1", "", echo.HeaderOrigin, "https://evil.example")
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != "" {
+		t.Fatalf("allowed origin = %q for an unlisted origin", got)
+	}
+}
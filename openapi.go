@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// openAPIPrefixes selects the routes described by /openapi.json.
+var openAPIPrefixes = []string{"/user", "/event", "/patch", "/parse_date"}
+
+// openAPISchemas are the types described once under components and
+// referenced everywhere else.
+var openAPISchemas = map[reflect.Type]string{
+	reflect.TypeOf(User{}):       "User",
+	reflect.TypeOf(Backpack{}):   "Backpack",
+	reflect.TypeOf(Event{}):      "Event",
+	reflect.TypeOf(EventsList{}): "EventsList",
+	reflect.TypeOf(APIError{}):   "APIError",
+}
+
+// apiOperation documents what the route table does not tell about a route.
+type apiOperation struct {
+	summary  string
+	query    []string
+	body     any
+	response any
+}
+
+var apiOperations = map[string]apiOperation{
+	"GET /parse_date":       {summary: "Parse a date in one of the accepted layouts", query: []string{CreatedAtParam}, response: time.Time{}},
+	"POST /user":            {summary: "Create a user", body: User{}, response: User{}},
+	"PUT /user/update/:id":  {summary: "Create or update a user", query: []string{SkipAuditParam, ForceEventParam}, body: User{}, response: UserUpdate{}},
+	"GET /user/:id":         {summary: "Get a user", query: []string{IncludeDeletedParam, TimeParam}, response: User{}},
+	"DELETE /user/:id":      {summary: "Delete a user"},
+	"PATCH /user/:id":       {summary: "Apply an RFC 6902 patch to a user", response: User{}},
+	"PATCH /user/:id/merge": {summary: "Apply an RFC 7386 merge patch to a user", response: User{}},
+	"GET /user/:id/history": {summary: "List the events of a user", query: []string{LimitParam, OffsetParam}, response: EventsList{}},
+	"GET /user/:id/at":      {summary: "Get the state of a user at a time", query: []string{CreatedAtParam}, response: User{}},
+	"GET /users":            {summary: "List users", query: []string{TagParam, MinAgeParam, IncludeDeletedParam}, response: []User{}},
+	"GET /events":           {summary: "List events", query: append([]string{LimitParam, OffsetParam, AfterIDParam, AsParam, TimeParam}, eventFilterParams...), response: EventsList{}},
+	"GET /event/:id":        {summary: "Get an event", response: Event{}},
+	"GET /patch/:patch_type/:event_id/:entity_id": {
+		summary:  "Reconstruct a user by replaying the patches of its events from an event on",
+		query:    []string{MetaParam, PreviewParam},
+		response: User{},
+	},
+}
+
+// openAPI serves an OpenAPI 3 document of the routes under openAPIPrefixes.
+func openAPI(c echo.Context) error {
+	return c.JSON(http.StatusOK, openAPIDocument(c.Echo().Routes()))
+}
+
+func openAPIDocument(routes []*echo.Route) map[string]any {
+	schemas := map[string]any{}
+	for t, name := range openAPISchemas {
+		schemas[name] = structSchema(t)
+	}
+
+	paths := map[string]map[string]any{}
+	for _, r := range routes {
+		if !hasAnyPrefix(r.Path, openAPIPrefixes) {
+			continue
+		}
+		path, params := openAPIPath(r.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(r.Method)] = openAPIOperation(r, params)
+	}
+
+	return map[string]any{
+		"openapi":    "3.0.3",
+		"info":       map[string]any{"title": "dt-server", "version": "1"},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// openAPIPath converts an echo path to the OpenAPI form and describes its
+// path parameters, all integers but the patch type.
+func openAPIPath(path string) (string, []any) {
+	segments := strings.Split(path, "/")
+	params := []any{}
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		name := segment[1:]
+		segments[i] = "{" + name + "}"
+		schema := map[string]any{"type": "integer", "format": "int64"}
+		if name == "patch_type" {
+			schema = map[string]any{"type": "string", "enum": sortedKeys(patchTypes)}
+		}
+		params = append(params, map[string]any{"name": name, "in": "path", "required": true, "schema": schema})
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func openAPIOperation(r *echo.Route, params []any) map[string]any {
+	op := apiOperations[r.Method+" "+r.Path]
+	for _, name := range op.query {
+		params = append(params, map[string]any{"name": name, "in": "query", "schema": map[string]any{"type": "string"}})
+	}
+
+	ok := map[string]any{"description": "success"}
+	if op.response != nil {
+		ok["content"] = jsonContent(reflect.TypeOf(op.response))
+	}
+	operation := map[string]any{
+		"operationId": strings.TrimPrefix(r.Name, "main."),
+		"parameters":  params,
+		"responses": map[string]any{
+			"200":     ok,
+			"default": map[string]any{"description": "error", "content": jsonContent(reflect.TypeOf(APIError{}))},
+		},
+	}
+	if op.summary != "" {
+		operation["summary"] = op.summary
+	}
+	if op.body != nil {
+		operation["requestBody"] = map[string]any{"required": true, "content": jsonContent(reflect.TypeOf(op.body))}
+	}
+	return operation
+}
+
+func jsonContent(t reflect.Type) map[string]any {
+	return map[string]any{echo.MIMEApplicationJSON: map[string]any{"schema": typeSchema(t)}}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// typeSchema describes t, referencing the types in openAPISchemas.
+func typeSchema(t reflect.Type) map[string]any {
+	if name, ok := openAPISchemas[t]; ok {
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		return map[string]any{}
+	}
+}
+
+// structSchema describes the JSON form of the struct type t.
+func structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = typeSchema(f.Type)
+	}
+	return map[string]any{"type": "object", "properties": properties}
+}
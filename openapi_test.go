@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	type parameter struct {
+		Name   string         `json:"name"`
+		In     string         `json:"in"`
+		Schema map[string]any `json:"schema"`
+	}
+	type operation struct {
+		OperationID string         `json:"operationId"`
+		Parameters  []parameter    `json:"parameters"`
+		RequestBody map[string]any `json:"requestBody"`
+	}
+	type document struct {
+		OpenAPI    string                          `json:"openapi"`
+		Paths      map[string]map[string]operation `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	e := newTestServer(newSeededStore())
+
+	rec := request(t, e, http.MethodGet, "/openapi.json", "")
+	expectStatus(t, rec, http.StatusOK)
+	doc := decodeBody[document](t, rec)
+	if doc.OpenAPI != "3.0.3" {
+		t.Fatalf("openapi = %q", doc.OpenAPI)
+	}
+	if _, ok := doc.Paths["/healthz"]; ok {
+		t.Fatal("/healthz is documented")
+	}
+
+	user := doc.Paths["/user/{id}"]
+	for _, method := range []string{"get", "delete", "patch"} {
+		if _, ok := user[method]; !ok {
+			t.Errorf("%s /user/{id} is not documented", method)
+		}
+	}
+	get := user["get"]
+	// handlers are named after the package path, main only outside of tests
+	if !strings.HasSuffix(get.OperationID, ".getUserByID") || len(get.Parameters) == 0 {
+		t.Fatalf("GET /user/{id} = %+v", get)
+	}
+	if id := get.Parameters[0]; id.Name != "id" || id.In != "path" || id.Schema["format"] != "int64" {
+		t.Fatalf("id parameter = %+v", id)
+	}
+	if doc.Paths["/user"]["post"].RequestBody == nil {
+		t.Fatal("POST /user has no request body")
+	}
+
+	patch := doc.Paths["/patch/{patch_type}/{event_id}/{entity_id}"]["get"]
+	if len(patch.Parameters) < 3 || patch.Parameters[0].Schema["enum"] == nil {
+		t.Fatalf("patch parameters = %+v", patch.Parameters)
+	}
+
+	userSchema := doc.Components.Schemas["User"]
+	if userSchema.Properties["bag"]["$ref"] != "#/components/schemas/Backpack" || userSchema.Properties["version"]["type"] != "integer" {
+		t.Fatalf("User schema = %+v", userSchema)
+	}
+}
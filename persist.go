@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+)
+
+// LoadFromFile replaces the state with the snapshot at path. A missing file
+// leaves the store empty.
+func (s *Store) LoadFromFile(path string) error {
+	serialized, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s.load(&StoreDump{})
+	}
+	if err != nil {
+		return err
+	}
+
+	d := &StoreDump{}
+	err = json.Unmarshal(serialized, d)
+	if err != nil {
+		return err
+	}
+
+	return s.load(d)
+}
+
+// SaveToFile writes a snapshot of the state to path. The snapshot is written
+// to a temporary file first and renamed over path, so a crash never leaves a
+// partial snapshot behind. Once saved, the WAL is no longer needed. Writers
+// are kept out from the dump until the WAL is truncated, as a change recorded
+// in between would be in neither.
+func (s *Store) SaveToFile(path string) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	serialized, err := json.Marshal(s.snapshot())
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(serialized)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	if s.wal != nil {
+		return s.wal.Truncate()
+	}
+	return nil
+}
+
+// saveAfterWrite saves the store to path after every successful request that
+// is not a GET.
+func saveAfterWrite(s *Store, path string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			if c.Request().Method == http.MethodGet || c.Response().Status >= http.StatusBadRequest {
+				return err
+			}
+			if saveErr := s.SaveToFile(path); saveErr != nil {
+				getLogger(c).Error("save store", "error", saveErr)
+			}
+			return err
+		}
+	}
+}
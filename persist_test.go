@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// TestSaveAfterWriteConcurrent is meant for go test -race: saving after one
+// write must not read the users while another write replaces them.
+func TestSaveAfterWriteConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s := newSeededStore()
+	e := newTestServer(s)
+	e.Use(saveAfterWrite(s, path))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				id := 10*w + i + 2
+				request(t, e, http.MethodPut, fmt.Sprintf("/user/update/%d", id), fmt.Sprintf(`{"id":%d,"name":"N","age":20}`, id))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	loaded := NewStore()
+	if err := loaded.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.users) != 41 || len(loaded.events) != 40 {
+		t.Fatalf("loaded %d users and %d events", len(loaded.users), len(loaded.events))
+	}
+}
+
+func TestSaveKeepsTheNextEventID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/users/bulk", `[{"id":1,"name":"A","age":20,"version":1},{"id":1,"name":"B","age":20,"version":1}]`), http.StatusUnprocessableEntity)
+	if err := s.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewStore()
+	if err := loaded.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded.mu.Lock()
+	next := loaded.nextEventID()
+	loaded.mu.Unlock()
+	if next != 2 {
+		t.Fatalf("next event id = %d after reload, want the aborted id 1 skipped", next)
+	}
+}
+
+func TestLoadDumpWithoutNextIDs(t *testing.T) {
+	s := NewStore()
+	err := s.load(&StoreDump{Events: []*Event{
+		{ID: 3, EntityID: 1, Action: "user_update", Rollback: []byte(`[]`), Update: []byte(`[]`)},
+		{ID: 7, EntityID: 1, Action: "user_update", Rollback: []byte(`[]`), Update: []byte(`[]`)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	next := s.nextEventID()
+	s.mu.Unlock()
+	if next != 8 {
+		t.Fatalf("next event id = %d, want the one after the loaded events", next)
+	}
+}
+
+func TestSaveAndLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s := newSeededStore()
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":17,"bag":{"phone":"Pixel","food":"Big tasty"},"version":1}`, HeaderTicket, "T-1"), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"name":"Ann","age":30,"tags":["vip"]}`), http.StatusCreated)
+	if err := s.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewStore()
+	if err := loaded.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.users, s.users) {
+		t.Fatalf("loaded users %+v, want %+v", loaded.users, s.users)
+	}
+	if len(loaded.events) != 2 || loaded.events[0].Metadata["ticket"] != "T-1" || !loaded.events[0].CreatedAt.Equal(s.events[0].CreatedAt) {
+		t.Fatalf("loaded events %+v", loaded.events)
+	}
+
+	// reloaded patches still reconstruct the user
+	e = newTestServer(loaded)
+	rec := request(t, e, http.MethodGet, "/patch/rollback/1/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Age != 16 || *u.Bag != (Backpack{Phone: "Poco F3", Food: "Big tasty", Gun: "Beretta"}) {
+		t.Fatalf("user reconstructed after the reload = %+v", u)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	s := newSeededStore()
+	if err := s.LoadFromFile(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.users) != 0 || len(s.events) != 0 {
+		t.Fatalf("%d users, %d events after loading a missing file", len(s.users), len(s.events))
+	}
+
+	path := filepath.Join(t.TempDir(), "broken.json")
+	if err := os.WriteFile(path, []byte(`{"users":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LoadFromFile(path); err == nil {
+		t.Fatal("loaded a broken file")
+	}
+}
+
+func TestSaveAfterWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s := newSeededStore()
+	e := newTestServer(s)
+	e.Use(saveAfterWrite(s, path))
+
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1", ""), http.StatusOK)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("a GET saved the store: %v", err)
+	}
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"tags":[""]}`), http.StatusUnprocessableEntity)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("a failed write saved the store: %v", err)
+	}
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`), http.StatusOK)
+	loaded := NewStore()
+	if err := loaded.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.users[1].Name != "A" || len(loaded.events) != 1 {
+		t.Fatalf("saved user %+v, %d events", loaded.users[1], len(loaded.events))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// validatePointer checks that p is a syntactically valid RFC 6901 JSON
+// Pointer. The empty pointer refers to the whole document and is valid.
+func validatePointer(p string) error {
+	if p == "" {
+		return nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("invalid pointer %q: must start with '/'", p)
+	}
+
+	for i := 0; i < len(p); i++ {
+		if p[i] != '~' {
+			continue
+		}
+		if i+1 >= len(p) || (p[i+1] != '0' && p[i+1] != '1') {
+			return fmt.Errorf("invalid pointer %q: '~' at offset %d must be escaped as '~0' or '~1'", p, i)
+		}
+		i++
+	}
+
+	return nil
+}
+
+// resolvePointer returns the value the valid pointer p refers to within the
+// decoded JSON document doc, and whether there is one.
+func resolvePointer(doc any, p string) (any, bool) {
+	if p == "" {
+		return doc, true
+	}
+	for _, token := range strings.Split(p[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch v := doc.(type) {
+		case map[string]any:
+			child, ok := v[token]
+			if !ok {
+				return nil, false
+			}
+			doc = child
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
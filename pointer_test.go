@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidatePointer(t *testing.T) {
+	for _, p := range []string{"", "/", "/bag/phone", "/a~0b/c~1d", "/tags/0", "//"} {
+		if err := validatePointer(p); err != nil {
+			t.Errorf("%q: %v", p, err)
+		}
+	}
+	for p, msg := range map[string]string{
+		"bag":     "must start with '/'",
+		"/a~":     "'~' at offset 2",
+		"/a~2":    "'~' at offset 2",
+		"/a/~~0":  "'~' at offset 3",
+		"/ok/b~x": "'~' at offset 5",
+	} {
+		err := validatePointer(p)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: err = %v, want %s", p, err, msg)
+		}
+	}
+}
+
+func TestFindEventsRejectsInvalidPointers(t *testing.T) {
+	e := newTestServer(newSeededStore())
+
+	for _, p := range []string{"bag", "/bag~3"} {
+		rec := request(t, e, http.MethodGet, "/events/find?path="+url.QueryEscape(p), "")
+		expectStatus(t, rec, http.StatusBadRequest)
+		if apiErr := decodeBody[APIError](t, rec); !strings.HasPrefix(apiErr.Message, "invalid pointer") {
+			t.Errorf("%q: message = %q", p, apiErr.Message)
+		}
+	}
+}
+
+func TestResolvePointer(t *testing.T) {
+	var doc any
+	if err := json.Unmarshal([]byte(`{"a/b":{"c~d":[1,{"e":"f"}]},"n":null}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		p     string
+		value any
+		ok    bool
+	}{
+		{"/a~1b/c~0d/1/e", "f", true},
+		{"/a~1b/c~0d/0", 1.0, true},
+		{"/n", nil, true},
+		{"/a~1b/c~0d/2", nil, false},
+		{"/a~1b/c~0d/-1", nil, false},
+		{"/missing", nil, false},
+		{"/n/deeper", nil, false},
+	}
+	for _, tt := range tests {
+		value, ok := resolvePointer(doc, tt.p)
+		if ok != tt.ok || value != tt.value {
+			t.Errorf("%q: %v, %v, want %v, %v", tt.p, value, ok, tt.value, tt.ok)
+		}
+	}
+}
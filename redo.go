@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UserRedoAction is the action of the events written by redo.
+const UserRedoAction = "user_redo"
+
+var errNothingToRedo = errors.New("latest event of the user is not a rollback to redo")
+
+// redoUser reapplies the changes undone by the latest event of the user,
+// which must be a rollback not redone yet.
+func redoUser(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.redo(entityID, initiator(c), subject(c), eventMetadata(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, errNothingToRedo):
+			return writeError(c, http.StatusConflict, CodeConflict, err.Error())
+		case errors.Is(err, errUserLocked):
+			return writeError(c, http.StatusLocked, CodeLocked, err.Error())
+		}
+		return writeLookupError(c, err)
+	}
+	if u == nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return c.JSON(http.StatusOK, u)
+}
+
+// redo reverts the latest event of user id, a rollback, and records the
+// result as a redo event. The rollback is then marked as reverted by the redo
+// and the events it reverted no longer are. It returns nil if the user does
+// not exist afterwards. The redo event is initiated by initiator for subject.
+// The caller must hold s.mu.
+func (s *Store) redo(id int64, initiator, subject string, metadata map[string]string) (*User, error) {
+	var rollback *Event
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if s.events[i].changes(UserEntity, id) {
+			rollback = s.events[i]
+			break
+		}
+	}
+	if rollback == nil {
+		if _, err := s.getUser(id); err != nil {
+			return nil, err
+		}
+		return nil, errNothingToRedo
+	}
+	if !rollback.IsRollback || rollback.RevertedByEventID != nil {
+		return nil, errNothingToRedo
+	}
+	redone := []int{}
+	for i, e := range s.events {
+		if e.RevertedByEventID != nil && *e.RevertedByEventID == rollback.ID {
+			redone = append(redone, i)
+		}
+	}
+	if len(redone) == 0 {
+		return nil, errNothingToRedo
+	}
+
+	old := s.users[id]
+	if old != nil && old.Locked {
+		return nil, errUserLocked
+	}
+	source, err := s.currentState(id)
+	if err != nil {
+		return nil, err
+	}
+	// the rollback patch of the rollback leads to the state after the redone
+	// events, while their own update patches test the versions they replaced
+	source, err = patch(rollback, RollbackType, source)
+	if err != nil {
+		return nil, err
+	}
+	var u *User
+	if string(source) != "null" {
+		u = &User{}
+		err = json.Unmarshal(source, u)
+		if err != nil {
+			return nil, err
+		}
+		if old != nil {
+			u.Version = old.Version + 1
+		} else {
+			u.Version++
+		}
+	}
+
+	e, err := s.newEvent(initiator, subject, id, UserRedoAction, old, u)
+	if err != nil {
+		return nil, err
+	}
+	e.Metadata = metadata
+	if u == nil {
+		delete(s.users, id)
+	} else {
+		s.users[id] = u
+	}
+	err = s.recordEvent(e, u)
+	if err != nil {
+		if old == nil {
+			delete(s.users, id)
+		} else {
+			s.users[id] = old
+		}
+		return nil, err
+	}
+
+	err = s.markReverted(rollback.ID, e.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range redone {
+		err = s.updateEvent(i, func(r *Event) {
+			r.RevertedByEventID = nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedoUser(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+
+	expectStatus(t, request(t, e, http.MethodPost, "/user/2/redo", ""), http.StatusConflict)
+	expectStatus(t, request(t, e, http.MethodPost, "/events/3/rollback", ""), http.StatusOK)
+	rec := request(t, e, http.MethodPost, "/user/2/redo", "", HeaderReason, "undo the undo")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "Cid" || u.Version != 5 || s.users[2].Name != "Cid" {
+		t.Fatalf("redone user = %+v", u)
+	}
+
+	redo := s.events[4]
+	if redo.Action != UserRedoAction || redo.Metadata["reason"] != "undo the undo" {
+		t.Fatalf("redo event = %+v", redo)
+	}
+	if rollback := s.events[3]; rollback.RevertedByEventID == nil || *rollback.RevertedByEventID != redo.ID {
+		t.Fatalf("rollback is not reverted by the redo: %+v", rollback)
+	}
+	if s.events[2].RevertedByEventID != nil {
+		t.Fatal("the redone event is still marked reverted")
+	}
+	expectStatus(t, request(t, e, http.MethodPost, "/user/2/redo", ""), http.StatusConflict)
+
+	// a rollback of the redo can be redone in turn
+	expectStatus(t, request(t, e, http.MethodPost, "/events/5/rollback", ""), http.StatusOK)
+	if s.users[2].Name != "Bea" {
+		t.Fatalf("user after rolling back the redo = %+v", s.users[2])
+	}
+	expectStatus(t, request(t, e, http.MethodPost, "/user/2/redo", ""), http.StatusOK)
+	if s.users[2].Name != "Cid" {
+		t.Fatalf("user after the second redo = %+v", s.users[2])
+	}
+}
+
+func TestRedoUserErrors(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	expectStatus(t, request(t, e, http.MethodPost, "/user/x/redo", ""), http.StatusBadRequest)
+	expectStatus(t, request(t, e, http.MethodPost, "/user/9/redo", ""), http.StatusNotFound)
+	expectStatus(t, request(t, e, http.MethodPost, "/user/1/redo", ""), http.StatusConflict)
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"Jo","age":16,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/events/1/rollback", ""), http.StatusOK)
+	s.users[1].Locked = true
+	expectStatus(t, request(t, e, http.MethodPost, "/user/1/redo", ""), http.StatusLocked)
+	if s.users[1].Name != "John" || len(s.events) != 2 {
+		t.Fatal("redo changed a locked user")
+	}
+}
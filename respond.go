@@ -0,0 +1,77 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+)
+
+const MIMEApplicationYAML = "application/yaml"
+
+// yamlMediaTypes are the Accept media types answered with YAML.
+var yamlMediaTypes = map[string]bool{
+	MIMEApplicationYAML:  true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+}
+
+// acceptsYAML reports whether the Accept header of the request names a YAML
+// media type.
+func acceptsYAML(c echo.Context) bool {
+	for _, accepted := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && yamlMediaTypes[mediaType] {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalBody serializes v as YAML when the request accepts it and as JSON
+// otherwise, returning the body and its content type.
+func marshalBody(c echo.Context, v any) ([]byte, string, error) {
+	body, err := marshalJSON(c, v)
+	if err != nil {
+		return nil, "", err
+	}
+	if !acceptsYAML(c) {
+		return body, echo.MIMEApplicationJSONCharsetUTF8, nil
+	}
+	body, err = jsonToYAML(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, MIMEApplicationYAML, nil
+}
+
+// jsonToYAML converts a JSON document to block style YAML, keeping the order
+// of its fields.
+func jsonToYAML(serialized []byte) ([]byte, error) {
+	doc := &yaml.Node{}
+	err := yaml.Unmarshal(serialized, doc)
+	if err != nil {
+		return nil, err
+	}
+	clearStyle(doc)
+	return yaml.Marshal(doc)
+}
+
+func clearStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, child := range n.Content {
+		clearStyle(child)
+	}
+}
+
+// respond writes v with the given status as YAML or JSON, depending on the
+// Accept header of the request.
+func respond(c echo.Context, status int, v any) error {
+	body, contentType, err := marshalBody(c, v)
+	if err != nil {
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+	return c.Blob(status, contentType, body)
+}
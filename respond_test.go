@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestYAMLResponses(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+
+	rec := request(t, e, http.MethodGet, "/user/1", "", echo.HeaderAccept, "text/html, application/yaml;q=0.9")
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != MIMEApplicationYAML {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	// the fields keep the order of the JSON document
+	if body := rec.Body.String(); !strings.HasPrefix(body, "id: 1\nname: John\nage: 16\nbag:\n    phone: Poco F3\n") {
+		t.Fatalf("body = %q", body)
+	}
+
+	rec = request(t, e, http.MethodGet, "/events?action=user_create", "", echo.HeaderAccept, "text/yaml")
+	expectStatus(t, rec, http.StatusOK)
+	if body := rec.Body.String(); !strings.HasPrefix(body, "events:\n    - id: 1\n") || !strings.Contains(body, "total: 1") {
+		t.Fatalf("events body = %q", body)
+	}
+
+	rec = request(t, e, http.MethodGet, "/users", "")
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != echo.MIMEApplicationJSONCharsetUTF8 {
+		t.Fatalf("Content-Type without YAML in Accept = %q", ct)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UserRestoreAction is the action of the events written by restore.
+const UserRestoreAction = "user_restore"
+
+var (
+	errEventOfOtherUser = errors.New("event did not change this user")
+	errNoStateAfter     = errors.New("user did not exist after this event")
+)
+
+// restoreUser makes the state the user had right after an event its current
+// state, recording the change as a restore event.
+func restoreUser(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	eventID, err := paramInt64(c, "event_id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.restore(entityID, eventID, initiator(c), subject(c), eventMetadata(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, errEventOfOtherUser):
+			return writeError(c, http.StatusNotFound, CodeNotFound, err.Error())
+		case errors.Is(err, errNoStateAfter):
+			return writeError(c, http.StatusUnprocessableEntity, CodeInvalidInput, err.Error())
+		case errors.Is(err, errUserLocked):
+			return writeError(c, http.StatusLocked, CodeLocked, err.Error())
+		}
+		return writeLookupError(c, err)
+	}
+
+	getLogger(c).Info("user restored", "user_id", entityID, "event_id", eventID)
+	return c.JSON(http.StatusOK, u)
+}
+
+// restore rolls user id back through its events after eventID and records
+// the result as its new state with a restore event initiated by initiator for
+// subject. The caller must hold s.mu.
+func (s *Store) restore(id, eventID int64, initiator, subject string, metadata map[string]string) (*User, error) {
+	e, err := s.getEvent(eventID)
+	if err != nil {
+		return nil, err
+	}
+	if !e.changes(UserEntity, id) {
+		return nil, errEventOfOtherUser
+	}
+	old := s.users[id]
+	if old != nil && old.Locked {
+		return nil, errUserLocked
+	}
+
+	source, err := s.currentState(id)
+	if err != nil {
+		return nil, err
+	}
+	source, err = s.rollbackState(id, source, func(e *Event) bool {
+		return e.ID > eventID
+	})
+	if err != nil {
+		return nil, err
+	}
+	if string(source) == "null" {
+		return nil, errNoStateAfter
+	}
+	u := &User{}
+	err = json.Unmarshal(source, u)
+	if err != nil {
+		return nil, err
+	}
+	// like a rollback, the restore is a change of its own
+	if old != nil {
+		u.Version = old.Version + 1
+	} else {
+		u.Version++
+	}
+
+	restored, err := s.newEvent(initiator, subject, id, UserRestoreAction, old, u)
+	if err != nil {
+		return nil, err
+	}
+	restored.Metadata = metadata
+	s.users[id] = u
+	err = s.recordEvent(restored, u)
+	if err != nil {
+		if old == nil {
+			delete(s.users, id)
+		} else {
+			s.users[id] = old
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestRestoreUser(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+
+	for _, ev := range []struct {
+		id      int64
+		name    string
+		version int64
+	}{{2, "Bea", 4}, {1, "Ann", 5}, {4, "Bea", 6}} {
+		rec := request(t, e, http.MethodPost, "/user/2/restore/"+strconv.FormatInt(ev.id, 10), "", HeaderTicket, "T-1")
+		expectStatus(t, rec, http.StatusOK)
+		u := decodeBody[User](t, rec)
+		if u.Name != ev.name || u.Version != ev.version || s.users[2].Name != ev.name {
+			t.Fatalf("restored to %d: %+v, want %s version %d", ev.id, u, ev.name, ev.version)
+		}
+		restore := s.events[len(s.events)-1]
+		if restore.Action != UserRestoreAction || restore.Metadata["ticket"] != "T-1" {
+			t.Fatalf("restore event = %+v", restore)
+		}
+	}
+	if len(s.events) != 6 {
+		t.Fatalf("%d events, want every restore recorded", len(s.events))
+	}
+}
+
+func TestRestoreUserErrors(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"Jo","age":16,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodDelete, "/user/2", ""), http.StatusNoContent)
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":3,"name":"Eve","age":30}`), http.StatusCreated)
+	expectStatus(t, request(t, e, http.MethodPost, "/events/6/rollback", ""), http.StatusNoContent)
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/user/x/restore/1", http.StatusBadRequest},
+		{"/user/1/restore/x", http.StatusBadRequest},
+		{"/user/1/restore/99", http.StatusNotFound},
+		// event 4 changed user 1, not user 2
+		{"/user/2/restore/4", http.StatusNotFound},
+		// user 3 did not exist after its creation was rolled back by event 7
+		{"/user/3/restore/7", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		expectStatus(t, request(t, e, http.MethodPost, tt.target, ""), tt.status)
+	}
+
+	// a deleted user can be brought back
+	rec := request(t, e, http.MethodPost, "/user/2/restore/3", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := s.users[2]; u.Name != "Cid" || u.Deleted {
+		t.Fatalf("restored deleted user = %+v", u)
+	}
+
+	s.users[2].Locked = true
+	expectStatus(t, request(t, e, http.MethodPost, "/user/2/restore/1", ""), http.StatusLocked)
+}
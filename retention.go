@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// retentionInterval is how often runRetention prunes the event log.
+const retentionInterval = time.Hour
+
+// RetentionPolicy selects the events of every user kept by prune: the ones
+// younger than Days days and the latest Count ones. Zero values disable the
+// respective limit.
+type RetentionPolicy struct {
+	Days  int
+	Count int
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.Days > 0 || p.Count > 0
+}
+
+// prune collapses, for every user, the events the policy does not keep into
+// a single baseline event, like truncateHistory does, so the current state and
+// the state before the kept events can still be reconstructed. It returns the
+// number of events pruned.
+func (s *Store) prune(p RetentionPolicy) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !p.enabled() {
+		return 0, nil
+	}
+	cutoff := s.now().AddDate(0, 0, -p.Days)
+	users := make(map[int64]bool)
+	young := make(map[int64]int)
+	for _, e := range s.events {
+		if e.entityType() != UserEntity {
+			continue
+		}
+		users[e.EntityID] = true
+		if p.Days > 0 && !e.CreatedAt.Before(cutoff) {
+			young[e.EntityID]++
+		}
+	}
+
+	ids := make([]int64, 0, len(users))
+	for id := range users {
+		ids = append(ids, id)
+	}
+	sortIDs(ids)
+
+	pruned := 0
+	for _, id := range ids {
+		u, err := s.lookupUser(id, true)
+		if err != nil {
+			// users removed by rolling back their creation have no state to
+			// roll back from
+			continue
+		}
+		keep := p.Count
+		if p.Days > 0 && young[id] > keep {
+			keep = young[id]
+		}
+		collapsed, err := s.collapseEvents(u, keep)
+		if err != nil {
+			return pruned, err
+		}
+		// the newest collapsed event is replaced by the baseline
+		if collapsed > 0 {
+			pruned += collapsed - 1
+		}
+	}
+
+	return pruned, nil
+}
+
+// runRetention prunes the event log with p every interval until ctx is done.
+func (s *Store) runRetention(ctx context.Context, p RetentionPolicy, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		n, err := s.prune(p)
+		if err != nil {
+			logger.Error("prune events", "error", err)
+			continue
+		}
+		logger.Info("pruned events", "events", n)
+	}
+}
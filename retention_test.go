@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		policy RetentionPolicy
+		pruned int
+	}{
+		{RetentionPolicy{}, 0},
+		{RetentionPolicy{Count: 1}, 1},
+		{RetentionPolicy{Count: 2}, 0},
+		// only the last event is younger than a day
+		{RetentionPolicy{Days: 1}, 1},
+		{RetentionPolicy{Days: 1, Count: 2}, 0},
+	}
+	for _, tt := range tests {
+		s := newSeededStore()
+		fakeClock(s, clockStart)
+		e := newTestServer(s)
+		seedHistory(t, e)
+		expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"Jo","age":16,"version":1}`), http.StatusOK)
+		s.now = func() time.Time { return clockStart.Add(24*time.Hour + 150*time.Second) }
+
+		pruned, err := s.prune(tt.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pruned != tt.pruned || len(s.events) != 4-pruned {
+			t.Fatalf("%+v pruned %d events, %d left, want %d pruned", tt.policy, pruned, len(s.events), tt.pruned)
+		}
+		if pruned > 0 && s.events[0].Action != UserBaselineAction {
+			t.Fatalf("%+v: first event = %+v, want a baseline", tt.policy, s.events[0])
+		}
+
+		// the current state and the state before the kept events survive
+		for target, name := range map[string]string{
+			"/user/2":             "Cid",
+			"/patch/rollback/3/2": "Bea",
+			"/patch/rollback/4/1": "John",
+		} {
+			rec := request(t, e, http.MethodGet, target, "")
+			expectStatus(t, rec, http.StatusOK)
+			if u := decodeBody[User](t, rec); u.Name != name {
+				t.Errorf("%+v: %s = %+v, want %s", tt.policy, target, u, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+// serve runs r on addr until ctx is done, then shuts r down, waiting up to
+// timeout for in-flight requests, and flushes the persistence layer. The flush
+// comes after draining so it includes the changes of those last requests.
+func serve(ctx context.Context, r *echo.Echo, addr string, timeout time.Duration, flush func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.Start(addr)
+	}()
+
+	select {
+	case err := <-errs:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutdown: stopping new requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := r.Shutdown(shutdownCtx)
+	if err != nil {
+		logger.Error("shutdown", "error", err)
+	}
+
+	logger.Info("shutdown: flushing store")
+	if flush != nil {
+		if flushErr := flush(); flushErr != nil {
+			logger.Error("shutdown: flush", "error", flushErr)
+			if err == nil {
+				err = flushErr
+			}
+		}
+	}
+
+	logger.Info("shutdown: done")
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServeShutsDownOnCancel(t *testing.T) {
+	e := newTestServer(newSeededStore())
+	e.HideBanner = true
+	e.HidePort = true
+	ctx, cancel := context.WithCancel(context.Background())
+	flushed := false
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, e, "127.0.0.1:0", time.Second, func() error {
+			flushed = true
+			return nil
+		})
+	}()
+
+	var addr net.Addr
+	for i := 0; addr == nil && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		addr = e.ListenerAddr()
+	}
+	if addr == nil {
+		t.Fatal("server did not start")
+	}
+	resp, err := http.Get("http://" + addr.String() + "/user/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("serve: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve did not return within the shutdown timeout")
+	}
+	if !flushed {
+		t.Fatal("store not flushed on shutdown")
+	}
+}
+
+func TestServeReportsFlushErrors(t *testing.T) {
+	e := newTestServer(newSeededStore())
+	e.HideBanner = true
+	e.HidePort = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// the flush error is expected
+	logLevel.Set(slog.LevelError + 1)
+	t.Cleanup(func() { logLevel.Set(slog.LevelError) })
+
+	errFlush := errors.New("disk full")
+	err := serve(ctx, e, "127.0.0.1:0", time.Second, func() error { return errFlush })
+	if !errors.Is(err, errFlush) {
+		t.Fatalf("serve = %v, want %v", err, errFlush)
+	}
+}
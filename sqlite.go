@@ -0,0 +1,312 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id   INTEGER PRIMARY KEY,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS events (
+	id            INTEGER PRIMARY KEY,
+	created_at    INTEGER NOT NULL,
+	initiator     TEXT NOT NULL,
+	subject       TEXT NOT NULL,
+	entity_id     INTEGER NOT NULL,
+	action        TEXT NOT NULL,
+	rollback      TEXT NOT NULL,
+	"update"      TEXT NOT NULL,
+	is_rollback   INTEGER NOT NULL,
+	reverted_by   INTEGER,
+	entity_type   TEXT NOT NULL DEFAULT '',
+	snapshot      TEXT,
+	superseded_by INTEGER,
+	metadata      TEXT
+);
+CREATE INDEX IF NOT EXISTS events_created_at ON events (created_at);
+CREATE INDEX IF NOT EXISTS events_initiator ON events (initiator);
+`
+
+// sqliteAddedColumns are the events columns added after the table was first
+// created. OpenSQLite adds them to databases that predate them.
+var sqliteAddedColumns = []string{
+	`entity_type TEXT NOT NULL DEFAULT ''`,
+	`snapshot TEXT`,
+	`superseded_by INTEGER`,
+	`metadata TEXT`,
+}
+
+// sqliteBackend stores users as JSON documents and events as rows whose
+// rollback and update patches are JSON columns. Event times are stored as
+// Unix nanoseconds, so the created_at index orders them.
+type sqliteBackend struct {
+	db *sql.DB
+}
+
+func OpenSQLite(path string) (*sqliteBackend, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// a single connection serializes writes and keeps :memory: databases
+	// from being opened once per connection
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, column := range sqliteAddedColumns {
+		_, err = db.Exec(`ALTER TABLE events ADD COLUMN ` + column)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &sqliteBackend{db: db}, nil
+}
+
+func (b *sqliteBackend) Close() error {
+	return b.db.Close()
+}
+
+func (b *sqliteBackend) GetUser(id int64) (*User, error) {
+	var data string
+	err := b.db.QueryRow(`SELECT data FROM users WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	u := &User{}
+	err = json.Unmarshal([]byte(data), u)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (b *sqliteBackend) ListUsers() ([]*User, error) {
+	rows, err := b.db.Query(`SELECT data FROM users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*User{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		u := &User{}
+		if err := json.Unmarshal([]byte(data), u); err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+func (b *sqliteBackend) SaveUser(u *User) error {
+	return saveUser(b.db, u)
+}
+
+func (b *sqliteBackend) DeleteUser(id int64) error {
+	_, err := b.db.Exec(`DELETE FROM users WHERE id = ?`, id)
+	return err
+}
+
+func (b *sqliteBackend) AppendEvent(e *Event) error {
+	return appendEvent(b.db, e)
+}
+
+func (b *sqliteBackend) DeleteEvents(ids ...int64) error {
+	for _, id := range ids {
+		if _, err := b.db.Exec(`DELETE FROM events WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *sqliteBackend) ReplaceAll(users map[int64]*User, events []*Event) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM users`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM events`); err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err := saveUser(tx, u); err != nil {
+			return err
+		}
+	}
+	for _, e := range events {
+		if err := appendEvent(tx, e); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListEvents supports the same filters as Store.getEventsList, evaluated by
+// the database.
+func (b *sqliteBackend) ListEvents(filters map[string]string) ([]*Event, error) {
+	where := []string{}
+	args := []any{}
+	for _, f := range []struct {
+		param, cond string
+	}{
+		{CreatedAtParam, "created_at >= ?"},
+		{CreatedFromParam, "created_at >= ?"},
+		{CreatedToParam, "created_at <= ?"},
+	} {
+		if filters[f.param] == "" {
+			continue
+		}
+		t, err := parseFlexibleDate(filters[f.param])
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, f.cond)
+		args = append(args, t.UnixNano())
+	}
+	for _, f := range []struct {
+		param, column string
+	}{
+		{InitiatorParam, "initiator"},
+		{SubjectParam, "subject"},
+		{ActionParam, "action"},
+	} {
+		if value, ok := filters[f.param]; ok {
+			where = append(where, f.column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if exclude, _ := strconv.ParseBool(filters[ExcludeRevertedParam]); exclude {
+		where = append(where, "reverted_by IS NULL")
+	}
+
+	query := `SELECT id, created_at, initiator, subject, entity_id, entity_type, action, rollback, "update", is_rollback, reverted_by, snapshot, superseded_by, metadata FROM events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id"
+
+	rows, err := b.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+	for rows.Next() {
+		e := &Event{}
+		var createdAt int64
+		var rollback, update string
+		var revertedBy, supersededBy sql.NullInt64
+		var snapshot, metadata sql.NullString
+		err := rows.Scan(&e.ID, &createdAt, &e.Initiator, &e.Subject, &e.EntityID, &e.EntityType, &e.Action, &rollback, &update, &e.IsRollback, &revertedBy, &snapshot, &supersededBy, &metadata)
+		if err != nil {
+			return nil, err
+		}
+		e.CreatedAt = time.Unix(0, createdAt).UTC()
+		if revertedBy.Valid {
+			e.RevertedByEventID = &revertedBy.Int64
+		}
+		if supersededBy.Valid {
+			e.SupersededByEventID = &supersededBy.Int64
+		}
+		if snapshot.Valid {
+			e.Snapshot = json.RawMessage(snapshot.String)
+		}
+		if metadata.Valid {
+			if err := json.Unmarshal([]byte(metadata.String), &e.Metadata); err != nil {
+				return nil, err
+			}
+		}
+		if err := json.Unmarshal([]byte(rollback), &e.Rollback); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(update), &e.Update); err != nil {
+			return nil, err
+		}
+		if err := normalizePatches(e); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func saveUser(db execer, u *User) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT OR REPLACE INTO users (id, data) VALUES (?, ?)`, u.ID, string(data))
+	return err
+}
+
+func appendEvent(db execer, e *Event) error {
+	rollback, err := json.Marshal(e.Rollback)
+	if err != nil {
+		return err
+	}
+	update, err := json.Marshal(e.Update)
+	if err != nil {
+		return err
+	}
+	metadata, err := metadataColumn(e.Metadata)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT OR REPLACE INTO events (id, created_at, initiator, subject, entity_id, entity_type, action, rollback, "update", is_rollback, reverted_by, snapshot, superseded_by, metadata)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.CreatedAt.UnixNano(), e.Initiator, e.Subject, e.EntityID, e.EntityType, e.Action, string(rollback), string(update), e.IsRollback, e.RevertedByEventID, snapshotColumn(e.Snapshot), e.SupersededByEventID, metadata)
+	return err
+}
+
+// snapshotColumn returns the value of the snapshot column, NULL for events
+// other than snapshots.
+func snapshotColumn(snapshot json.RawMessage) any {
+	if snapshot == nil {
+		return nil
+	}
+	return string(snapshot)
+}
+
+// metadataColumn returns the value of the metadata column, NULL for events
+// without metadata.
+func metadataColumn(metadata map[string]string) (any, error) {
+	if metadata == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(metadata)
+	return string(data), err
+}
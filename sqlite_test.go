@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newSQLiteStore(t *testing.T) (*Store, *sqliteBackend) {
+	t.Helper()
+	backend, err := OpenSQLite(filepath.Join(t.TempDir(), "dt.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	s := NewStore()
+	s.backend = backend
+	return s, backend
+}
+
+func TestSQLiteBackend(t *testing.T) {
+	s, backend := newSQLiteStore(t)
+	fakeClock(s, clockStart)
+	e := newTestServer(s)
+	seedHistory(t, e)
+	expectStatus(t, request(t, e, http.MethodPost, "/events/3/rollback", "", HeaderReason, "typo"), http.StatusOK)
+
+	u, err := backend.GetUser(2)
+	if err != nil || u.Name != "Bea" || u.Version != 4 {
+		t.Fatalf("stored user = %+v, %v", u, err)
+	}
+	if _, err := backend.GetUser(9); err != errUserNotFound {
+		t.Fatalf("missing user: %v", err)
+	}
+
+	tests := []struct {
+		filters map[string]string
+		ids     []int64
+	}{
+		{nil, []int64{1, 2, 3, 4}},
+		{map[string]string{CreatedFromParam: "2024-01-01T00:02:00Z", CreatedToParam: "2024-01-01T00:03:00Z"}, []int64{2, 3}},
+		{map[string]string{CreatedAtParam: "2024-01-01T00:03:00Z"}, []int64{3, 4}},
+		{map[string]string{InitiatorParam: "admin", ActionParam: "user_update"}, []int64{2, 3}},
+		{map[string]string{InitiatorParam: "nobody"}, []int64{}},
+		{map[string]string{ExcludeRevertedParam: "true"}, []int64{1, 2, 4}},
+	}
+	for _, tt := range tests {
+		events, err := backend.ListEvents(tt.filters)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.filters, err)
+		}
+		ids := []int64{}
+		for _, ev := range events {
+			ids = append(ids, ev.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.ids) {
+			t.Errorf("%v: events %v, want %v", tt.filters, ids, tt.ids)
+		}
+	}
+
+	events, err := backend.ListEvents(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollback := events[3]
+	if !rollback.IsRollback || rollback.Metadata["reason"] != "typo" || !rollback.CreatedAt.Equal(clockStart.Add(4*time.Minute)) {
+		t.Fatalf("stored rollback event = %+v", rollback)
+	}
+	if reverted := events[2].RevertedByEventID; reverted == nil || *reverted != 4 {
+		t.Fatalf("event 3 reverted by %v", reverted)
+	}
+	if string(events[1].Update) != string(s.events[1].Update) {
+		t.Fatalf("stored update %s, want %s", events[1].Update, s.events[1].Update)
+	}
+}
+
+func TestSQLiteBackendReload(t *testing.T) {
+	s, backend := newSQLiteStore(t)
+	e := newTestServer(s)
+	seedHistory(t, e)
+
+	loaded := NewStore()
+	loaded.backend = backend
+	if err := loaded.loadFromBackend(); err != nil {
+		t.Fatal(err)
+	}
+	e = newTestServer(loaded)
+	rec := request(t, e, http.MethodGet, "/patch/rollback/2/2", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Name != "Ann" {
+		t.Fatalf("user reconstructed from the database = %+v", u)
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/events?created_from=2024-01-02&created_to=2024-01-01", ""), http.StatusBadRequest)
+}
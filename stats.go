@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// EventStats summarizes the events selected by the filters of eventsList.
+type EventStats struct {
+	Events      int            `json:"events"`
+	ByAction    map[string]int `json:"by_action"`
+	ByInitiator map[string]int `json:"by_initiator"`
+	LatestAt    *time.Time     `json:"latest_at,omitempty"`
+}
+
+// eventStats counts the filtered events by action and by initiator.
+func eventStats(c echo.Context) error {
+	events, err := getStore(c).getEventsList(eventFilters(c))
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, summarizeEvents(events))
+}
+
+func summarizeEvents(events []*Event) *EventStats {
+	stats := &EventStats{Events: len(events), ByAction: map[string]int{}, ByInitiator: map[string]int{}}
+	for _, e := range events {
+		stats.ByAction[e.Action]++
+		stats.ByInitiator[e.Initiator]++
+		if stats.LatestAt == nil || e.CreatedAt.After(*stats.LatestAt) {
+			createdAt := e.CreatedAt
+			stats.LatestAt = &createdAt
+		}
+	}
+	return stats
+}
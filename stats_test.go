@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventStats(t *testing.T) {
+	s := newSeededStore()
+	fakeClock(s, clockStart)
+	e := newTestServer(s)
+	seedHistory(t, e)
+	expectStatus(t, request(t, e, http.MethodPost, "/admin/compact", ""), http.StatusOK)
+
+	rec := request(t, e, http.MethodGet, "/stats", "")
+	expectStatus(t, rec, http.StatusOK)
+	stats := decodeBody[EventStats](t, rec)
+	if stats.Events != 4 ||
+		!reflect.DeepEqual(stats.ByAction, map[string]int{UserCreateAction: 1, "user_update": 2, UserSnapshotAction: 1}) ||
+		!reflect.DeepEqual(stats.ByInitiator, map[string]int{defaultPrincipal: 3, "system": 1}) {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if want := clockStart.Add(4 * time.Minute); stats.LatestAt == nil || !stats.LatestAt.Equal(want) {
+		t.Fatalf("latest_at = %v, want %v", stats.LatestAt, want)
+	}
+
+	rec = request(t, e, http.MethodGet, "/stats?action=user_update", "")
+	if stats := decodeBody[EventStats](t, rec); stats.Events != 2 || !stats.LatestAt.Equal(clockStart.Add(3*time.Minute)) {
+		t.Fatalf("filtered stats = %+v", stats)
+	}
+	rec = request(t, e, http.MethodGet, "/stats?initiator=nobody", "")
+	if stats := decodeBody[EventStats](t, rec); stats.Events != 0 || stats.LatestAt != nil {
+		t.Fatalf("stats of no events = %+v", stats)
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/stats?created_at=never", ""), http.StatusBadRequest)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/labstack/echo/v4"
+)
+
+const storeContextKey = "store"
+
+// Store holds the users and the event log describing their changes.
+//
+// Methods serving a whole request, like getPatched or listUsers, take mu
+// themselves. The building blocks they share, like getUser and addEvent,
+// expect the caller to hold it, so handlers combining them lock mu around the
+// whole read-modify-write.
+type Store struct {
+	mu     sync.RWMutex
+	users  map[int64]*User
+	events []*Event
+	// lastEventID is the ID of the latest event appended by addEvent, set
+	// when events are loaded or replayed, so appends need not scan the log
+	lastEventID int64
+
+	// wal, when set, receives every appended event
+	wal *WAL
+	// backend, when set, receives every change
+	backend Backend
+	// now stamps new events, tests may replace it with a fake clock
+	now func() time.Time
+
+	// patched caches getPatched results, versions tells which are current
+	patched  *lru.Cache[patchedKey, patchedEntry]
+	versions map[int64]uint64
+
+	// stream receives every appended event for streamEvents
+	stream *broadcaster
+	// batch, when set, holds appended events back from wal and stream
+	batch *eventBatch
+
+	// saveMu orders SaveToFile calls, so an older snapshot never replaces a
+	// newer one
+	saveMu sync.Mutex
+}
+
+func NewStore() *Store {
+	return &Store{
+		users:  make(map[int64]*User),
+		events: []*Event{},
+		now:    func() time.Time { return time.Now().UTC() },
+
+		patched:  newPatchedCache(),
+		versions: make(map[int64]uint64),
+
+		stream: newBroadcaster(),
+	}
+}
+
+func newSeededStore() *Store {
+	s := NewStore()
+	s.users[1] = &User{
+		ID:      1,
+		Name:    "John",
+		Age:     16,
+		Version: 1,
+		Bag: &Backpack{
+			Phone: "Poco F3",
+			Food:  "Big tasty",
+			Gun:   "Beretta",
+		}}
+	return s
+}
+
+// withStore makes s available to handlers through getStore, so handlers can
+// be registered against any store, e.g. a fresh one per test.
+func withStore(s *Store) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(storeContextKey, s)
+			return next(c)
+		}
+	}
+}
+
+func getStore(c echo.Context) *Store {
+	return c.Get(storeContextKey).(*Store)
+}
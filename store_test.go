@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkConcurrentUpdates updates distinct users from parallel goroutines
+// and checks that every update appended its event.
+func BenchmarkConcurrentUpdates(b *testing.B) {
+	s := NewStore()
+	e := newTestServer(s)
+	var ids atomic.Int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id := ids.Add(1)
+			rec := request(b, e, http.MethodPut, fmt.Sprintf("/user/update/%d", id), fmt.Sprintf(`{"id":%d,"name":"N","age":20}`, id))
+			if rec.Code != http.StatusOK {
+				b.Errorf("update %d: status %d", id, rec.Code)
+			}
+		}
+	})
+	b.StopTimer()
+
+	if got, want := len(s.events), int(ids.Load()); got != want {
+		b.Fatalf("%d events after %d updates", got, want)
+	}
+}
+
+// BenchmarkAddEvent measures a single append, without the HTTP handling
+// around it.
+func BenchmarkAddEvent(b *testing.B) {
+	s := NewStore()
+	old := &User{ID: 1, Name: "John", Age: 16, Version: 1, Bag: &Backpack{Phone: "Poco F3"}}
+	updated := &User{ID: 1, Name: "John", Age: 17, Version: 2, Bag: &Backpack{Phone: "Poco F3"}}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.mu.Lock()
+		err := s.addEvent("admin", "", 1, "user_update", old, updated, nil)
+		s.mu.Unlock()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// memBackend is an in-memory Backend, standing in for a database.
+type memBackend struct {
+	users  map[int64]*User
+	events map[int64]*Event
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{users: make(map[int64]*User), events: make(map[int64]*Event)}
+}
+
+func (b *memBackend) GetUser(id int64) (*User, error) {
+	if u, ok := b.users[id]; ok {
+		return u, nil
+	}
+	return nil, errUserNotFound
+}
+
+func (b *memBackend) ListUsers() ([]*User, error) {
+	list := make([]*User, 0, len(b.users))
+	for _, u := range b.users {
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+func (b *memBackend) SaveUser(u *User) error {
+	copied := *u
+	b.users[u.ID] = &copied
+	return nil
+}
+
+func (b *memBackend) DeleteUser(id int64) error {
+	delete(b.users, id)
+	return nil
+}
+
+func (b *memBackend) AppendEvent(e *Event) error {
+	b.events[e.ID] = e
+	return nil
+}
+
+func (b *memBackend) DeleteEvents(ids ...int64) error {
+	for _, id := range ids {
+		delete(b.events, id)
+	}
+	return nil
+}
+
+func (b *memBackend) ListEvents(filters map[string]string) ([]*Event, error) {
+	list := make([]*Event, 0, len(b.events))
+	for _, e := range b.events {
+		if matchesFilter(filters, ActionParam, e.Action) {
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (b *memBackend) ReplaceAll(users map[int64]*User, events []*Event) error {
+	*b = *newMemBackend()
+	for _, u := range users {
+		b.SaveUser(u)
+	}
+	for _, e := range events {
+		b.AppendEvent(e)
+	}
+	return nil
+}
+
+func (b *memBackend) Close() error {
+	return nil
+}
+
+// TestHandlersWithFakeBackend serves requests against a store of its own,
+// writing through to an in-memory fake backend, and checks what reached it.
+func TestHandlersWithFakeBackend(t *testing.T) {
+	backend := newMemBackend()
+	s := NewStore()
+	s.backend = backend
+	e := newTestServer(s)
+
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":1,"name":"Ann","age":30}`), http.StatusCreated)
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":2,"name":"Bob","age":40}`), http.StatusCreated)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"Bea","age":30,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodDelete, "/user/2", ""), http.StatusNoContent)
+
+	if u := backend.users[1]; u == nil || u.Name != "Bea" || u.Version != 2 {
+		t.Fatalf("backend user 1 = %+v", u)
+	}
+	if u := backend.users[2]; u == nil || !u.Deleted {
+		t.Fatalf("backend user 2 = %+v, want it soft-deleted", u)
+	}
+	if len(backend.events) != 4 {
+		t.Fatalf("backend holds %d events, want 4", len(backend.events))
+	}
+
+	// listing events goes to the backend
+	rec := request(t, e, http.MethodGet, "/events?action=user_create", "")
+	expectStatus(t, rec, http.StatusOK)
+	if list := decodeBody[EventsList](t, rec); list.Total != 2 {
+		t.Fatalf("created events = %+v", list.Events)
+	}
+
+	// a fresh store loads the state back
+	loaded := NewStore()
+	loaded.backend = backend
+	if err := loaded.loadFromBackend(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.users) != 2 || len(loaded.events) != 4 || loaded.users[1].Name != "Bea" {
+		t.Fatalf("loaded %d users, %d events", len(loaded.users), len(loaded.events))
+	}
+}
+
+// TestConcurrentRequests runs updates and reads of the same user in
+// parallel; run with -race.
+func TestConcurrentRequests(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	const writers = 20
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			rec := request(t, e, http.MethodPatch, "/user/1", fmt.Sprintf(`[{"op":"replace","path":"/age","value":%d}]`, 20+i))
+			if rec.Code != http.StatusOK {
+				t.Errorf("patch %d: status %d", i, rec.Code)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for _, target := range []string{"/user/1", "/events", "/users", "/patch/rollback/1/1"} {
+				request(t, e, http.MethodGet, target, "")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(s.events) != writers || s.users[1].Version != writers+1 {
+		t.Fatalf("%d events, version %d after %d patches", len(s.events), s.users[1].Version, writers)
+	}
+	for i, ev := range s.events {
+		if ev.ID != int64(i+1) {
+			t.Fatalf("event %d has id %d", i, ev.ID)
+		}
+	}
+}
+
+// TestConcurrentEventMarks is meant for -race: marking events reverted or
+// superseded must not change the events readers are serializing.
+func TestConcurrentEventMarks(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	const updates = 10
+	for i := 0; i < updates; i++ {
+		expectStatus(t, request(t, e, http.MethodPatch, "/user/1", fmt.Sprintf(`[{"op":"replace","path":"/age","value":%d}]`, 20+i)), http.StatusOK)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := updates; i > 0; i-- {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			request(t, e, http.MethodPost, fmt.Sprintf("/events/%d/rollback", i), "")
+			request(t, e, http.MethodPost, "/admin/compact", "")
+			request(t, e, http.MethodPost, "/user/1/redo", "")
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for _, target := range []string{"/events", fmt.Sprintf("/event/%d", i), "/events/export", "/user/1/history"} {
+				request(t, e, http.MethodGet, target, "")
+			}
+		}(i)
+	}
+	wg.Wait()
+}
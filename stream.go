@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	SpeedParam = "speed"
+
+	HeaderLastEventID = "Last-Event-ID"
+
+	maxReplayDuration = 5 * time.Minute
+
+	// streamBuffer is how many events a stream subscriber may lag behind
+	// before it is dropped
+	streamBuffer = 64
+)
+
+func writeSSE(w *echo.Response, e *Event, format string) error {
+	serialized, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data, err := formatTimes(serialized, format)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: event\ndata: %s\n\n", e.ID, data)
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// replayEvents streams the event log keeping the original gaps between
+// events, divided by the speed factor. The stream stops when the client
+// disconnects or once maxReplayDuration has elapsed.
+func replayEvents(c echo.Context) error {
+	speed := 1.0
+	if c.QueryParam(SpeedParam) != "" {
+		var err error
+		speed, err = strconv.ParseFloat(c.QueryParam(SpeedParam), 64)
+		if err != nil || speed <= 0 {
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, "speed must be a positive number")
+		}
+	}
+
+	s := getStore(c)
+	s.mu.RLock()
+	replay := make([]*Event, len(s.events))
+	copy(replay, s.events)
+	s.mu.RUnlock()
+
+	ctx := c.Request().Context()
+	deadline := time.NewTimer(maxReplayDuration)
+	defer deadline.Stop()
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	for i, e := range replay {
+		if i > 0 {
+			gap := time.Duration(float64(e.CreatedAt.Sub(replay[i-1].CreatedAt)) / speed)
+			if gap > 0 {
+				t := time.NewTimer(gap)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+					return nil
+				case <-deadline.C:
+					t.Stop()
+					return nil
+				}
+			}
+		}
+
+		if err := writeSSE(w, e, timeFormat(c)); err != nil {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// broadcaster fans the appended events out to the subscribed streams.
+type broadcaster struct {
+	mu   sync.Mutex
+	subs map[chan *Event]bool
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{subs: make(map[chan *Event]bool)}
+}
+
+// subscribe returns a channel receiving the events published from now on and
+// a function ending the subscription. The channel is closed when the
+// subscriber falls more than streamBuffer events behind.
+func (b *broadcaster) subscribe() (<-chan *Event, func()) {
+	ch := make(chan *Event, streamBuffer)
+	b.mu.Lock()
+	b.subs[ch] = true
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subs[ch] {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish hands e to every subscriber without blocking, dropping the ones
+// whose buffer is full.
+func (b *broadcaster) publish(e *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// streamEvents pushes every newly appended event to the client as it is
+// recorded. A Last-Event-ID header first replays the events recorded after
+// that one, so reconnecting clients miss nothing. Clients dropped for lagging
+// behind can reconnect the same way.
+func streamEvents(c echo.Context) error {
+	var lastID int64
+	if header := c.Request().Header.Get(HeaderLastEventID); header != "" {
+		var err error
+		lastID, err = strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, "Last-Event-ID must be a 64-bit integer")
+		}
+	}
+
+	s := getStore(c)
+	events, unsubscribe := s.stream.subscribe()
+	defer unsubscribe()
+	missed := []*Event{}
+	if lastID > 0 {
+		s.mu.RLock()
+		for _, e := range s.events {
+			if e.ID > lastID {
+				missed = append(missed, e)
+			}
+		}
+		s.mu.RUnlock()
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	format := timeFormat(c)
+	for _, e := range missed {
+		if err := writeSSE(w, e, format); err != nil {
+			return nil
+		}
+		lastID = e.ID
+	}
+
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			// events recorded while the missed ones were collected arrive
+			// twice
+			if e.ID <= lastID {
+				continue
+			}
+			if err := writeSSE(w, e, format); err != nil {
+				return nil
+			}
+			lastID = e.ID
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readSSE reads the next event of an event stream.
+func readSSE(t *testing.T, r *bufio.Reader) *Event {
+	t.Helper()
+	e := &Event{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return e
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			if err := json.Unmarshal([]byte(data), e); err != nil {
+				t.Fatalf("event data %s: %v", data, err)
+			}
+		}
+	}
+}
+
+// openStream connects to the event stream of e, sending headers.
+func openStream(t *testing.T, e http.Handler, headers ...string) *bufio.Reader {
+	t.Helper()
+	srv := httptest.NewServer(e)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		srv.Close()
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream response %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(res.Body)
+}
+
+func TestStreamEvents(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	stream := openStream(t, e)
+
+	seedHistory(t, e)
+	for id := int64(1); id <= 3; id++ {
+		if ev := readSSE(t, stream); ev.ID != id || ev.EntityID != 2 {
+			t.Fatalf("streamed event = %+v, want event %d", ev, id)
+		}
+	}
+}
+
+func TestStreamEventsReplaysMissedEvents(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+	seedHistory(t, e)
+	stream := openStream(t, e, HeaderLastEventID, "1")
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"Jo","age":16,"version":1}`), http.StatusOK)
+	for id := int64(2); id <= 4; id++ {
+		if ev := readSSE(t, stream); ev.ID != id {
+			t.Fatalf("streamed event %d, want %d", ev.ID, id)
+		}
+	}
+
+	expectStatus(t, request(t, e, http.MethodGet, "/events/stream", "", HeaderLastEventID, "x"), http.StatusBadRequest)
+}
+
+func TestReplayEvents(t *testing.T) {
+	s := newSeededStore()
+	for id := int64(1); id <= 3; id++ {
+		s.events = append(s.events, &Event{ID: id, CreatedAt: clockStart.Add(time.Duration(id) * time.Minute), EntityID: 1, Action: "user_update"})
+	}
+	e := newTestServer(s)
+
+	// a minute between events at speed 6000 is 10ms
+	start := time.Now()
+	rec := request(t, e, http.MethodGet, "/events/replay?speed=6000", "")
+	expectStatus(t, rec, http.StatusOK)
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("replay took %v, want the gaps between events kept", elapsed)
+	}
+	replay := bufio.NewReader(rec.Body)
+	for id := int64(1); id <= 3; id++ {
+		if ev := readSSE(t, replay); ev.ID != id || !ev.CreatedAt.Equal(clockStart.Add(time.Duration(id)*time.Minute)) {
+			t.Fatalf("replayed event = %+v, want event %d", ev, id)
+		}
+	}
+
+	for _, speed := range []string{"0", "-1", "fast"} {
+		expectStatus(t, request(t, e, http.MethodGet, "/events/replay?speed="+speed, ""), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	TimeParam = "time"
+
+	TimeRFC3339     = "rfc3339"
+	TimeRFC3339Nano = "rfc3339nano"
+	TimeEpoch       = "epoch"
+
+	// time fields are recognized by this suffix of their JSON name
+	timeFieldSuffix = "_at"
+)
+
+var errUnknownTimeFormat = errors.New("time must be one of rfc3339, rfc3339nano, epoch")
+
+// timeFormat returns the time format requested with ?time=, defaulting to
+// RFC3339 with whole seconds.
+func timeFormat(c echo.Context) string {
+	switch f := c.QueryParam(TimeParam); f {
+	case TimeRFC3339Nano, TimeEpoch:
+		return f
+	default:
+		return TimeRFC3339
+	}
+}
+
+func checkTimeFormat(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		switch c.QueryParam(TimeParam) {
+		case "", TimeRFC3339, TimeRFC3339Nano, TimeEpoch:
+			return next(c)
+		default:
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, errUnknownTimeFormat.Error())
+		}
+	}
+}
+
+// timeJSONSerializer writes responses with their time fields in the format
+// requested by the client.
+type timeJSONSerializer struct {
+	echo.DefaultJSONSerializer
+}
+
+func (timeJSONSerializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	serialized, err := marshalJSON(c, i)
+	if err != nil {
+		return err
+	}
+	if indent != "" {
+		indented := &bytes.Buffer{}
+		if err := json.Indent(indented, serialized, "", indent); err != nil {
+			return err
+		}
+		serialized = indented.Bytes()
+	}
+	_, err = c.Response().Write(append(serialized, '\n'))
+	return err
+}
+
+// marshalJSON marshals v and rewrites its time fields for the request.
+func marshalJSON(c echo.Context, v any) ([]byte, error) {
+	serialized, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return formatTimes(serialized, timeFormat(c))
+}
+
+type jsonFrame struct {
+	object    bool
+	expectKey bool
+	n         int
+}
+
+// formatTimes re-emits the JSON document token by token, so field order and
+// numbers are kept, converting the RFC3339 string values of time fields.
+func formatTimes(serialized []byte, format string) ([]byte, error) {
+	if format == TimeRFC3339Nano {
+		return serialized, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(serialized))
+	dec.UseNumber()
+	out := &bytes.Buffer{}
+	stack := []*jsonFrame{}
+	lastKey := ""
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			out.WriteByte(byte(d))
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		var top *jsonFrame
+		isKey := false
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+			switch {
+			case top.object && top.expectKey:
+				if top.n > 0 {
+					out.WriteByte(',')
+				}
+				isKey = true
+			case top.object:
+				out.WriteByte(':')
+			case top.n > 0:
+				out.WriteByte(',')
+			}
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			out.WriteByte(byte(v))
+			stack = append(stack, &jsonFrame{object: v == '{', expectKey: true})
+		case string:
+			if isKey {
+				lastKey = v
+			} else if top != nil && top.object && strings.HasSuffix(lastKey, timeFieldSuffix) {
+				if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+					out.WriteString(formatTime(t, format))
+					break
+				}
+			}
+			quoted, _ := json.Marshal(v)
+			out.Write(quoted)
+		case json.Number:
+			out.WriteString(v.String())
+		case bool:
+			out.WriteString(strconv.FormatBool(v))
+		case nil:
+			out.WriteString("null")
+		}
+
+		if top != nil {
+			switch {
+			case isKey:
+				top.expectKey = false
+			case top.object:
+				top.expectKey = true
+				top.n++
+			default:
+				top.n++
+			}
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+func formatTime(t time.Time, format string) string {
+	if format == TimeEpoch {
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	quoted, _ := json.Marshal(t.Format(time.RFC3339))
+	return string(quoted)
+}
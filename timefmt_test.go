@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeFormats(t *testing.T) {
+	s := newSeededStore()
+	at := time.Date(2024, 1, 2, 3, 4, 5, 600_000_000, time.UTC)
+	s.now = func() time.Time { return at }
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":16,"version":1}`), http.StatusOK)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", `"2024-01-02T03:04:05Z"`},
+		{"&time=rfc3339", `"2024-01-02T03:04:05Z"`},
+		{"&time=rfc3339nano", `"2024-01-02T03:04:05.6Z"`},
+		{"&time=epoch", "1704164645600"},
+	}
+	for _, tt := range tests {
+		rec := request(t, e, http.MethodGet, "/events?created_at=2023-01-01T00:00:00Z"+tt.query, "")
+		expectStatus(t, rec, http.StatusOK)
+		ev := decodeBody[struct {
+			Events []map[string]json.RawMessage `json:"events"`
+		}](t, rec).Events[0]
+		if got := string(ev["created_at"]); got != tt.want {
+			t.Errorf("%q: created_at = %s, want %s", tt.query, got, tt.want)
+		}
+		// strings of other fields are left alone
+		if !strings.Contains(string(ev["update"]), `"A"`) {
+			t.Errorf("%q: update = %s", tt.query, ev["update"])
+		}
+	}
+
+	rec := request(t, e, http.MethodGet, "/events?created_at=2023-01-01T00:00:00Z&time=unix", "")
+	expectStatus(t, rec, http.StatusBadRequest)
+	if apiErr := decodeBody[APIError](t, rec); apiErr.Message != errUnknownTimeFormat.Error() {
+		t.Fatalf("message = %q", apiErr.Message)
+	}
+}
+
+func TestFormatTimesKeepsTheDocument(t *testing.T) {
+	doc := `{"b":1.50,"created_at":"2024-01-02T03:04:05.5Z","list":[{"x_at":"not a time"},null,true],"name_at":"2024-01-02T03:04:05Z"}`
+	got, err := formatTimes([]byte(doc), TimeEpoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"b":1.50,"created_at":1704164645500,"list":[{"x_at":"not a time"},null,true],"name_at":1704164645000}`
+	if string(got) != want {
+		t.Fatalf("formatted\n%s\nwant\n%s", got, want)
+	}
+}
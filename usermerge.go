@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/labstack/echo/v4"
+)
+
+// UserMergeAction is the action of events recorded by mergeUser.
+const UserMergeAction = "user_merge"
+
+// mergeUser applies the RFC 7386 JSON Merge Patch in the request body to a
+// user. Members set to null are removed, so {"bag": null} takes the bag away.
+// Bodies that are not a JSON object are rejected with 400.
+func mergeUser(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	// a merge patch that is not an object would replace the whole user
+	members := map[string]json.RawMessage{}
+	err = json.Unmarshal(body, &members)
+	if err != nil || members == nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, "malformed merge patch: expected a JSON object")
+	}
+
+	return changeUser(c, entityID, UserMergeAction, func(serialized []byte) ([]byte, error) {
+		return jsonpatch.MergePatch(serialized, body)
+	})
+}
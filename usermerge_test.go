@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMergeUser(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	rec := request(t, e, http.MethodPatch, "/user/1/merge", `{"bag":{"phone":"Pixel","gun":null}}`)
+	expectStatus(t, rec, http.StatusOK)
+	u := decodeBody[User](t, rec)
+	if u.Name != "John" || u.Bag == nil || *u.Bag != (Backpack{Phone: "Pixel", Food: "Big tasty"}) {
+		t.Fatalf("merged user = %+v", u)
+	}
+	if len(s.events) != 1 || s.events[0].Action != UserMergeAction {
+		t.Fatalf("events = %+v", s.events)
+	}
+
+	// rolling back the merge restores the nested fields
+	rec = request(t, e, http.MethodGet, "/patch/rollback/1/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); u.Bag == nil || u.Bag.Phone != "Poco F3" || u.Bag.Gun != "Beretta" {
+		t.Fatalf("rolled back user = %+v", u)
+	}
+
+	expectStatus(t, request(t, e, http.MethodPatch, "/user/1/merge", `{"bag":null}`), http.StatusOK)
+	if s.users[1].Bag != nil {
+		t.Fatalf("bag = %+v, want none", s.users[1].Bag)
+	}
+	for _, body := range []string{`[]`, `null`, `"x"`} {
+		expectStatus(t, request(t, e, http.MethodPatch, "/user/1/merge", body), http.StatusBadRequest)
+	}
+}
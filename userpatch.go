@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/labstack/echo/v4"
+)
+
+// UserPatchAction is the action of events recorded by patchUser.
+const UserPatchAction = "user_patch"
+
+// patchUser applies the RFC 6902 JSON Patch in the request body to a user.
+// Malformed patches are rejected with 400, patches that do not apply to the
+// current user with 409.
+func patchUser(c echo.Context) error {
+	entityID, err := paramInt64(c, "id")
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	p, err := jsonpatch.DecodePatch(body)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, "malformed patch: "+err.Error())
+	}
+	err = checkPatchOps(p)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	return changeUser(c, entityID, UserPatchAction, func(serialized []byte) ([]byte, error) {
+		return applyPatch(serialized, p)
+	})
+}
+
+// changeUser replaces user id with the result of apply on its JSON form,
+// recording the change as an event with action. Errors of apply are
+// answered with 409.
+func changeUser(c echo.Context, id int64, action string, apply func([]byte) ([]byte, error)) error {
+	s := getStore(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.getUser(id)
+	if err != nil {
+		return writeError(c, http.StatusNotFound, CodeNotFound, err.Error())
+	}
+	if old.Locked {
+		return writeError(c, http.StatusLocked, CodeLocked, errUserLocked.Error())
+	}
+	// the patch itself may test /version instead
+	expected, err := expectedVersion(c, old.Version)
+	if err != nil {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	if expected != old.Version {
+		return writeError(c, http.StatusConflict, CodeConflict, errVersionMismatch.Error())
+	}
+
+	serialized, err := json.Marshal(old)
+	if err != nil {
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+	patched, err := apply(serialized)
+	if err != nil {
+		return writeError(c, http.StatusConflict, CodeConflict, "patch does not apply: "+err.Error())
+	}
+	u := &User{}
+	err = json.Unmarshal(patched, u)
+	if err != nil {
+		return writeError(c, http.StatusUnprocessableEntity, CodeInvalidInput, err.Error())
+	}
+	u.ID = old.ID
+	// lock and delete state are changed only via their own endpoints
+	u.Locked = old.Locked
+	u.Deleted = old.Deleted
+	u.Version = old.Version + 1
+	setDerivedFields(u)
+	err = validateUser(u)
+	if err != nil {
+		return writeValidationError(c, err)
+	}
+	err = validateTags(u.Tags)
+	if err != nil {
+		return writeError(c, http.StatusUnprocessableEntity, CodeInvalidInput, err.Error())
+	}
+
+	s.users[u.ID] = u
+	if skipAudit(c) {
+		err = s.storeState(u.ID, u)
+	} else {
+		err = s.addEvent(initiator(c), subject(c), u.ID, action, old, u, eventMetadata(c))
+	}
+	if err != nil {
+		s.users[u.ID] = old
+		return writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+
+	getLogger(c).Info("user patched", "user_id", u.ID, "action", action)
+	return c.JSON(http.StatusOK, u)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPatchUser(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	rec := request(t, e, http.MethodPatch, "/user/1", `[{"op":"replace","path":"/age","value":20},{"op":"add","path":"/tags","value":["vip"]}]`)
+	expectStatus(t, rec, http.StatusOK)
+	u := decodeBody[User](t, rec)
+	if u.Age != 20 || !u.IsAdult || len(u.Tags) != 1 || u.Version != 2 {
+		t.Fatalf("patched user = %+v", u)
+	}
+	if len(s.events) != 1 || s.events[0].Action != UserPatchAction {
+		t.Fatalf("events = %+v", s.events)
+	}
+
+	expectStatus(t, request(t, e, http.MethodPatch, "/user/1", `{"op":"replace"}`), http.StatusBadRequest)
+	expectStatus(t, request(t, e, http.MethodPatch, "/user/1", `[{"op":"test","path":"/name","value":"Bob"},{"op":"replace","path":"/name","value":"A"}]`), http.StatusConflict)
+	expectStatus(t, request(t, e, http.MethodPatch, "/user/1", `[{"op":"replace","path":"/age","value":-1}]`), http.StatusUnprocessableEntity)
+	expectStatus(t, request(t, e, http.MethodPatch, "/user/1", `[{"op":"replace","path":"/name","value":"A"}]`, HeaderExpectedVersion, "1"), http.StatusConflict)
+	expectStatus(t, request(t, e, http.MethodPatch, "/user/2", `[]`), http.StatusNotFound)
+	if s.users[1].Name != "John" || len(s.events) != 1 {
+		t.Fatalf("rejected patches changed the user: %+v", s.users[1])
+	}
+
+	// the patch can't change the id of the user
+	expectStatus(t, request(t, e, http.MethodPatch, "/user/1", `[{"op":"replace","path":"/id","value":7}]`), http.StatusOK)
+	if _, ok := s.users[7]; ok || s.users[1].ID != 1 {
+		t.Fatal("patch moved the user")
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	TagParam            = "tag"
+	MinAgeParam         = "min_age"
+	IncludeDeletedParam = "include_deleted"
+	FieldsParam         = "fields"
+)
+
+// userFields are the JSON names of the top-level user fields ?fields= can
+// select.
+var userFields = jsonFieldNames(reflect.TypeOf(User{}))
+
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// parseFields parses the comma-separated ?fields= allowlist. Empty means all
+// fields and gives nil.
+func parseFields(raw string) ([]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	fields := []string{}
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		if !userFields[f] {
+			return nil, fmt.Errorf("unknown user field %q, expected some of %s", f, strings.Join(sortedKeys(userFields), ", "))
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// maskUser returns the JSON object of u holding only the given fields.
+// Omitted empty fields stay omitted.
+func maskUser(u *User, fields []string) (map[string]json.RawMessage, error) {
+	serialized, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	all := map[string]json.RawMessage{}
+	err = json.Unmarshal(serialized, &all)
+	if err != nil {
+		return nil, err
+	}
+	masked := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			masked[f] = v
+		}
+	}
+	return masked, nil
+}
+
+// AdultAge is the age from which User.IsAdult is set.
+const AdultAge = 18
+
+var errEmptyTag = errors.New("tags must not be empty")
+
+// setDerivedFields computes the fields of u that clients can't set.
+func setDerivedFields(u *User) {
+	u.IsAdult = u.Age >= AdultAge
+}
+
+func validateTags(tags []string) error {
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		if tag == "" {
+			return errEmptyTag
+		}
+		if seen[tag] {
+			return fmt.Errorf("duplicate tag %q", tag)
+		}
+		seen[tag] = true
+	}
+	return nil
+}
+
+// HeaderExpectedVersion carries the User.Version a mutating request expects
+// the user to have, overriding the version in the body.
+const HeaderExpectedVersion = "X-Expected-Version"
+
+var errVersionMismatch = errors.New("user version does not match the current one")
+
+// expectedVersion returns the version the request expects the user to have:
+// the one in HeaderExpectedVersion if set, fallback otherwise.
+func expectedVersion(c echo.Context, fallback int64) (int64, error) {
+	header := c.Request().Header.Get(HeaderExpectedVersion)
+	if header == "" {
+		return fallback, nil
+	}
+	version, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", HeaderExpectedVersion)
+	}
+	return version, nil
+}
+
+// MaxAge is the highest User.Age accepted by validateUser.
+const MaxAge = 150
+
+// FieldErrors maps the JSON names of invalid User fields to what is wrong
+// with them.
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	msgs := make([]string, len(fields))
+	for i, field := range fields {
+		msgs[i] = field + " " + e[field]
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validateUser checks the user about to be stored. It is called on the
+// complete new state, after defaults are applied and, for PATCH, after the
+// patch is merged into the current user, so an omitted Age counts as 0
+// rather than as an error.
+func validateUser(u *User) error {
+	errs := FieldErrors{}
+	if u.ID <= 0 {
+		errs["id"] = "must be a positive integer"
+	}
+	if u.Name == "" {
+		errs["name"] = "must not be empty"
+	}
+	if u.Age < 0 || u.Age > MaxAge {
+		errs["age"] = fmt.Sprintf("must be between 0 and %d", MaxAge)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func hasTag(u *User, tag string) bool {
+	for _, t := range u.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// includeDeleted reports whether the request asks for deleted users too.
+func includeDeleted(c echo.Context) bool {
+	include, _ := strconv.ParseBool(c.QueryParam(IncludeDeletedParam))
+	return include
+}
+
+func listUsers(c echo.Context) error {
+	tag := c.QueryParam(TagParam)
+	minAge := 0
+	if c.QueryParam(MinAgeParam) != "" {
+		var err error
+		minAge, err = strconv.Atoi(c.QueryParam(MinAgeParam))
+		if err != nil {
+			return writeError(c, http.StatusBadRequest, CodeBadRequest, "min_age must be an integer")
+		}
+	}
+
+	deleted := includeDeleted(c)
+	return respond(c, http.StatusOK, getStore(c).listUsers(func(u *User) bool {
+		return (deleted || !u.Deleted) && u.Age >= minAge && (tag == "" || hasTag(u, tag))
+	}))
+}
+
+// listUsers returns the users selected by include ordered by id.
+func (s *Store) listUsers(include func(u *User) bool) []*User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int64, 0, len(s.users))
+	for id, u := range s.users {
+		if include(u) {
+			ids = append(ids, id)
+		}
+	}
+	sortIDs(ids)
+
+	list := make([]*User, len(ids))
+	for i, id := range ids {
+		list[i] = s.users[id]
+	}
+	return list
+}
+
+const (
+	FieldParam = "field"
+	OpParam    = "op"
+)
+
+// numericUserFields lists the fields /users/aggregate can compute over.
+var numericUserFields = map[string]func(u *User) float64{
+	"id":  func(u *User) float64 { return float64(u.ID) },
+	"age": func(u *User) float64 { return float64(u.Age) },
+}
+
+var aggregateOps = map[string]func(values []float64) float64{
+	"sum": func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"avg": func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"min": func(values []float64) float64 {
+		min := values[0]
+		for _, v := range values[1:] {
+			if v < min {
+				min = v
+			}
+		}
+		return min
+	},
+	"max": func(values []float64) float64 {
+		max := values[0]
+		for _, v := range values[1:] {
+			if v > max {
+				max = v
+			}
+		}
+		return max
+	},
+}
+
+type Aggregate struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Count int    `json:"count"`
+	// Value is nil when there are no users to aggregate over
+	Value *float64 `json:"value"`
+}
+
+func aggregateUsers(c echo.Context) error {
+	field, op := c.QueryParam(FieldParam), c.QueryParam(OpParam)
+	value, ok := numericUserFields[field]
+	if !ok {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("field %q is not a numeric user field", field))
+	}
+	aggregate, ok := aggregateOps[op]
+	if !ok {
+		return writeError(c, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("unknown op %q, expected one of avg, min, max, sum", op))
+	}
+
+	list := getStore(c).listUsers(func(u *User) bool { return !u.Deleted })
+	result := &Aggregate{Field: field, Op: op, Count: len(list)}
+	if len(list) > 0 {
+		values := make([]float64, len(list))
+		for i, u := range list {
+			values[i] = value(u)
+		}
+		v := aggregate(values)
+		result.Value = &v
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// userDefaults is merged under newly created users. The client value always
+// wins, but since zero values are omitted from the JSON form of a user, a
+// field set to its zero value counts as absent and gets the default.
+var userDefaults = &User{}
+
+func parseUserDefaults(raw string) (*User, error) {
+	defaults := &User{}
+	if raw == "" {
+		return defaults, nil
+	}
+	err := json.Unmarshal([]byte(raw), defaults)
+	if err != nil {
+		return nil, err
+	}
+	// ids and lock state are never defaulted
+	defaults.ID = 0
+	defaults.Locked = false
+	return defaults, nil
+}
+
+func applyUserDefaults(u *User) (*User, error) {
+	defaults, err := json.Marshal(userDefaults)
+	if err != nil {
+		return nil, err
+	}
+	incoming, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := jsonpatch.MergePatch(defaults, incoming)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &User{}
+	err = json.Unmarshal(merged, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
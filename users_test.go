@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestWritesCannotSoftDelete(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"deleted":true,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPatch, "/user/1", `[{"op":"add","path":"/deleted","value":true}]`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPatch, "/user/1/merge", `{"deleted":true}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"B","age":20,"deleted":true}`), http.StatusOK)
+
+	for _, id := range []int64{1, 2} {
+		if s.users[id].Deleted {
+			t.Fatalf("user %d was deleted by a write", id)
+		}
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/user/1", ""), http.StatusOK)
+}
+
+// seedUsers adds users 2 to 4 to the seeded user 1, aged 16.
+func seedUsers(t *testing.T, e *echo.Echo) {
+	t.Helper()
+	for _, body := range []string{
+		`{"id":4,"name":"Dan","age":40,"tags":["vip","staff"]}`,
+		`{"id":2,"name":"Ann","age":18,"tags":["vip"]}`,
+		`{"id":3,"name":"Bob","age":30}`,
+	} {
+		expectStatus(t, request(t, e, http.MethodPost, "/user", body), http.StatusCreated)
+	}
+}
+
+func userIDs(users []*User) []int64 {
+	ids := make([]int64, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids
+}
+
+func TestListUsers(t *testing.T) {
+	e := newTestServer(newSeededStore())
+	seedUsers(t, e)
+	expectStatus(t, request(t, e, http.MethodDelete, "/user/3", ""), http.StatusNoContent)
+
+	tests := []struct {
+		query string
+		ids   []int64
+	}{
+		{"", []int64{1, 2, 4}},
+		{"min_age=18", []int64{2, 4}},
+		{"min_age=41", []int64{}},
+		{"tag=vip", []int64{2, 4}},
+		{"tag=staff&min_age=18", []int64{4}},
+		{"include_deleted=true", []int64{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		rec := request(t, e, http.MethodGet, "/users?"+tt.query, "")
+		expectStatus(t, rec, http.StatusOK)
+		if rec.Body.String() == "null\n" {
+			t.Errorf("%q: body is null, want an array", tt.query)
+		}
+		if ids := userIDs(decodeBody[[]*User](t, rec)); fmt.Sprint(ids) != fmt.Sprint(tt.ids) {
+			t.Errorf("%q: users %v, want %v", tt.query, ids, tt.ids)
+		}
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/users?min_age=old", ""), http.StatusBadRequest)
+}
+
+func TestUserTags(t *testing.T) {
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":16,"tags":["vip"],"version":1}`), http.StatusOK)
+	if !strings.Contains(string(s.events[0].Update), `"/tags"`) {
+		t.Fatalf("update %s does not change the tags", s.events[0].Update)
+	}
+	rec := request(t, e, http.MethodGet, "/patch/rollback/1/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if u := decodeBody[User](t, rec); len(u.Tags) != 0 {
+		t.Fatalf("rolled back tags = %q", u.Tags)
+	}
+	rec = request(t, e, http.MethodGet, "/users?tag=vip", "")
+	expectStatus(t, rec, http.StatusOK)
+	if users := decodeBody[[]*User](t, rec); len(users) != 1 || users[0].ID != 1 {
+		t.Fatalf("users tagged vip = %+v", users)
+	}
+
+	for _, body := range []string{`{"id":1,"name":"A","age":16,"tags":[""]}`, `{"id":1,"name":"A","age":16,"tags":["a","a"]}`} {
+		expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", body), http.StatusUnprocessableEntity)
+	}
+}
+
+func TestUserFieldMasking(t *testing.T) {
+	e := newTestServer(newSeededStore())
+
+	rec := request(t, e, http.MethodGet, "/user/1?fields=name,%20bag", "")
+	expectStatus(t, rec, http.StatusOK)
+	masked := decodeBody[map[string]json.RawMessage](t, rec)
+	if len(masked) != 2 || string(masked["name"]) != `"John"` || masked["bag"] == nil {
+		t.Fatalf("masked user = %s", rec.Body.String())
+	}
+	// empty fields stay omitted
+	rec = request(t, e, http.MethodGet, "/user/1?fields=id,tags", "")
+	expectStatus(t, rec, http.StatusOK)
+	if masked := decodeBody[map[string]json.RawMessage](t, rec); len(masked) != 1 || masked["id"] == nil {
+		t.Fatalf("masked user = %s", rec.Body.String())
+	}
+
+	rec = request(t, e, http.MethodGet, "/user/1?fields=name,password", "")
+	expectStatus(t, rec, http.StatusBadRequest)
+	if apiErr := decodeBody[APIError](t, rec); !strings.Contains(apiErr.Message, `"password"`) {
+		t.Fatalf("message = %q", apiErr.Message)
+	}
+}
+
+func TestAggregateUsers(t *testing.T) {
+	e := newTestServer(NewStore())
+
+	rec := request(t, e, http.MethodGet, "/users/aggregate?field=age&op=avg", "")
+	expectStatus(t, rec, http.StatusOK)
+	if res := decodeBody[Aggregate](t, rec); res.Count != 0 || res.Value != nil {
+		t.Fatalf("aggregate of no users = %+v", res)
+	}
+
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"Ann","age":16,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Bob","age":30}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/3", `{"id":3,"name":"Cid","age":20}`), http.StatusOK)
+	for op, want := range map[string]float64{"sum": 66, "avg": 22, "min": 16, "max": 30} {
+		rec := request(t, e, http.MethodGet, "/users/aggregate?field=age&op="+op, "")
+		expectStatus(t, rec, http.StatusOK)
+		if res := decodeBody[Aggregate](t, rec); res.Count != 3 || res.Value == nil || *res.Value != want {
+			t.Errorf("%s: aggregate = %+v, want %v", op, res, want)
+		}
+	}
+	expectStatus(t, request(t, e, http.MethodGet, "/users/aggregate?field=name&op=sum", ""), http.StatusBadRequest)
+	expectStatus(t, request(t, e, http.MethodGet, "/users/aggregate?field=age&op=median", ""), http.StatusBadRequest)
+}
+
+func TestUserDefaults(t *testing.T) {
+	defaults, err := parseUserDefaults(`{"id":9,"name":"Anonymous","age":18,"locked":true,"bag":{"food":"Apple"},"tags":["new"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaults.ID != 0 || defaults.Locked {
+		t.Fatalf("defaults = %+v, want no id nor lock", defaults)
+	}
+	userDefaults = defaults
+	t.Cleanup(func() { userDefaults = &User{} })
+	s := newSeededStore()
+	e := newTestServer(s)
+
+	rec := request(t, e, http.MethodPost, "/user", `{"age":30,"bag":{"phone":"Pixel"}}`)
+	expectStatus(t, rec, http.StatusCreated)
+	u := decodeBody[User](t, rec)
+	if u.ID != 2 || u.Name != "Anonymous" || u.Age != 30 || u.Locked || *u.Bag != (Backpack{Phone: "Pixel", Food: "Apple"}) || len(u.Tags) != 1 || !u.IsAdult {
+		t.Fatalf("created user = %+v", u)
+	}
+	// updates of existing users get no defaults
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"John","age":16,"version":1}`), http.StatusOK)
+	if s.users[1].Bag != nil || len(s.users[1].Tags) != 0 {
+		t.Fatalf("updated user = %+v", s.users[1])
+	}
+
+	if _, err := parseUserDefaults(`{"age":"old"}`); err == nil {
+		t.Fatal("invalid defaults parsed")
+	}
+}
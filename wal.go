@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// WAL is an append-only log of the mutations applied since the last
+// snapshot. Most lines hold a recorded event and the resulting state of its
+// entity, so replaying the lines in order restores both. The others hold the
+// changes made without appending an event: states changed unaudited, logged
+// events replaced, like the ones marked reverted, and removed ones.
+type WAL struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+type walRecord struct {
+	Event *Event          `json:"event,omitempty"`
+	State json.RawMessage `json:"state,omitempty"`
+	// Batch holds the records of an atomic batch, written as one line so
+	// that a crash keeps either all of them or none
+	Batch []*walRecord `json:"batch,omitempty"`
+
+	// EntityID is the entity State belongs to in records without an Event
+	EntityID int64 `json:"entity_id,omitempty"`
+	// Updated replaces the logged event with the same ID
+	Updated *Event `json:"updated,omitempty"`
+	// Removed lists the IDs of events dropped from the log
+	Removed []int64 `json:"removed,omitempty"`
+	// AfterEventID places records without an Event in the log: it is the ID
+	// of the latest event when they were written
+	AfterEventID int64 `json:"after_event_id,omitempty"`
+}
+
+func newWALRecord(e *Event, state any) (*walRecord, error) {
+	serialized, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	return &walRecord{Event: e, State: serialized}, nil
+}
+
+func OpenWAL(path string) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &WAL{f: f}, nil
+}
+
+func (w *WAL) Append(e *Event, state any) error {
+	rec, err := newWALRecord(e, state)
+	if err != nil {
+		return err
+	}
+	return w.write(rec)
+}
+
+// AppendBatch appends records as a single line.
+func (w *WAL) AppendBatch(records []*walRecord) error {
+	return w.write(&walRecord{Batch: records})
+}
+
+func (w *WAL) write(rec *walRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.f.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// Truncate drops every record. Call it once a snapshot containing them has
+// been written.
+func (w *WAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = w.f.Seek(0, 0)
+	return err
+}
+
+func (w *WAL) Close() error {
+	return w.f.Close()
+}
+
+// replayWAL applies the records of the WAL at path on top of the current
+// state. A missing file means there is nothing to recover. A torn last line,
+// left by a crash in the middle of a write, is ignored. Events the loaded
+// snapshot already holds are skipped: a crash between writing a snapshot and
+// truncating the WAL leaves both with the same events. So are the other
+// records written before its latest event, while the ones written after it
+// set states and events the snapshot may hold already to the same values.
+func (s *Store) replayWAL(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	records := []*walRecord{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		rec := &walRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			if !scanner.Scan() {
+				break
+			}
+			return 0, fmt.Errorf("wal line %d: %w", line, err)
+		}
+		if rec.Batch != nil {
+			records = append(records, rec.Batch...)
+		} else {
+			records = append(records, rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	covered := s.lastEventID
+	replayed := 0
+	for _, rec := range records {
+		if rec.Event == nil {
+			if rec.AfterEventID < covered {
+				continue
+			}
+			if err := s.replayChange(rec); err != nil {
+				return 0, err
+			}
+			replayed++
+			continue
+		}
+		if rec.Event.ID <= covered {
+			continue
+		}
+		if err := normalizePatches(rec.Event); err != nil {
+			return 0, err
+		}
+		if err := s.replayState(rec.Event.EntityID, rec.State); err != nil {
+			return 0, err
+		}
+		s.events = append(s.events, rec.Event)
+		s.lastEventID = rec.Event.ID
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// replayChange applies a record written without appending an event.
+func (s *Store) replayChange(rec *walRecord) error {
+	if rec.Updated != nil {
+		if err := normalizePatches(rec.Updated); err != nil {
+			return err
+		}
+		for i, e := range s.events {
+			if e.ID == rec.Updated.ID {
+				s.events[i] = rec.Updated
+			}
+		}
+	}
+	if len(rec.Removed) > 0 {
+		removed := make(map[int64]bool, len(rec.Removed))
+		for _, id := range rec.Removed {
+			removed[id] = true
+		}
+		kept := make([]*Event, 0, len(s.events))
+		for _, e := range s.events {
+			if !removed[e.ID] {
+				kept = append(kept, e)
+			}
+		}
+		s.events = kept
+	}
+	if rec.State != nil {
+		return s.replayState(rec.EntityID, rec.State)
+	}
+	return nil
+}
+
+// replayState sets the state of user id to the serialized state, deleting
+// the user for "null".
+func (s *Store) replayState(id int64, state json.RawMessage) error {
+	if string(state) == "null" {
+		delete(s.users, id)
+		return nil
+	}
+	u := &User{}
+	if err := json.Unmarshal(state, u); err != nil {
+		return err
+	}
+	s.users[id] = u
+	return nil
+}
+
+// normalizePatches checks that the rollback and update patches of a decoded
+// event are RFC 6902 patches and compacts them to the form new events get.
+func normalizePatches(e *Event) error {
+	for _, p := range []*json.RawMessage{&e.Rollback, &e.Update} {
+		if len(*p) == 0 {
+			continue
+		}
+		if _, err := jsonpatch.DecodePatch(*p); err != nil {
+			return err
+		}
+		compacted := &bytes.Buffer{}
+		if err := json.Compact(compacted, *p); err != nil {
+			return err
+		}
+		*p = compacted.Bytes()
+	}
+	return nil
+}
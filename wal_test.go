@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestWALReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	s := newSeededStore()
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.wal = wal
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":2,"name":"Ann","age":30}`), http.StatusCreated)
+	expectStatus(t, request(t, e, http.MethodPost, "/events/2/rollback", ""), http.StatusNoContent)
+	wal.Close()
+
+	// a crash in the middle of a write leaves a torn line behind
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"event":{"id":4`)
+	f.Close()
+
+	replayed := newSeededStore()
+	n, err := replayed.replayWAL(path)
+	// the rollback is followed by the record marking event 2 reverted
+	if err != nil || n != 4 {
+		t.Fatalf("replayed %d records: %v", n, err)
+	}
+	if replayed.users[1].Name != "A" || replayed.users[2] != nil || len(replayed.events) != 3 {
+		t.Fatalf("replayed users %+v, %d events", replayed.users, len(replayed.events))
+	}
+	if reverted := replayed.events[1].RevertedByEventID; reverted == nil || *reverted != 3 {
+		t.Fatalf("replayed event 2 is reverted by %v, want 3", reverted)
+	}
+	replayed.mu.Lock()
+	next := replayed.nextEventID()
+	replayed.mu.Unlock()
+	if next != 4 {
+		t.Fatalf("next event id = %d after the replay", next)
+	}
+	// the patches come back as the JSON they were appended as
+	for i, ev := range replayed.events {
+		if string(ev.Update) != string(s.events[i].Update) || string(ev.Rollback) != string(s.events[i].Rollback) {
+			t.Errorf("replayed event %d patches %s, %s, want %s, %s", ev.ID, ev.Update, ev.Rollback, s.events[i].Update, s.events[i].Rollback)
+		}
+	}
+}
+
+func TestWALReplayChangesWithoutEvents(t *testing.T) {
+	allowSkipAudit = true
+	defer func() { allowSkipAudit = false }()
+	path := filepath.Join(t.TempDir(), "wal")
+	s := newSeededStore()
+	fakeClock(s, clockStart)
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	s.wal = wal
+	e := newTestServer(s)
+	seedHistory(t, e)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`, HeaderSkipAudit, "true"), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/admin/compact", ""), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Dee","age":30,"version":3}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/events/5/rollback", ""), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/user/2/redo", ""), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/user/2/truncate?keep=2", ""), http.StatusOK)
+
+	replayed := newSeededStore()
+	if _, err := replayed.replayWAL(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed.users, s.users) {
+		t.Fatalf("replayed users %+v, want %+v", replayed.users, s.users)
+	}
+	if !reflect.DeepEqual(replayed.events, s.events) {
+		t.Fatalf("replayed events differ from the recorded ones")
+	}
+}
+
+func TestWALReplayAfterCrashBeforeTruncate(t *testing.T) {
+	dir := t.TempDir()
+	s := newSeededStore()
+	fakeClock(s, clockStart)
+	wal, err := OpenWAL(filepath.Join(dir, "wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	s.wal = wal
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`), http.StatusOK)
+	expectStatus(t, request(t, e, http.MethodPost, "/user", `{"id":2,"name":"Ann","age":30}`), http.StatusCreated)
+	expectStatus(t, request(t, e, http.MethodPost, "/events/1/rollback", ""), http.StatusOK)
+
+	// the snapshot is renamed into place, but the WAL is not truncated
+	serialized, err := s.dumpJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "store.json"), serialized, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/2", `{"id":2,"name":"Bea","age":30,"version":1}`), http.StatusOK)
+
+	recovered := NewStore()
+	if err := recovered.LoadFromFile(filepath.Join(dir, "store.json")); err != nil {
+		t.Fatal(err)
+	}
+	// the record marking event 1 reverted comes after the latest event of
+	// the snapshot, it sets the mark the snapshot has already
+	n, err := recovered.replayWAL(filepath.Join(dir, "wal"))
+	if err != nil || n != 2 {
+		t.Fatalf("replayed %d records: %v", n, err)
+	}
+	if !reflect.DeepEqual(recovered.users, s.users) {
+		t.Fatalf("recovered users %+v, want %+v", recovered.users, s.users)
+	}
+	if !reflect.DeepEqual(recovered.events, s.events) {
+		ids := []int64{}
+		for _, ev := range recovered.events {
+			ids = append(ids, ev.ID)
+		}
+		t.Fatalf("recovered events %v, want each event once", ids)
+	}
+}
+
+func TestWALReplayRejectsCorruptRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	if err := os.WriteFile(path, []byte("{\"event\":\n{\"event\":{\"id\":1,\"update\":[]},\"state\":{\"id\":1}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStore().replayWAL(path); err == nil {
+		t.Fatal("replayed a corrupt line that is not the last one")
+	}
+
+	if n, err := NewStore().replayWAL(filepath.Join(t.TempDir(), "missing")); err != nil || n != 0 {
+		t.Fatalf("missing wal: %d, %v", n, err)
+	}
+}
+
+func TestWALTruncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	if err := wal.Append(&Event{ID: 1, EntityID: 1}, &User{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Fatalf("wal after truncate: %v, %v", info, err)
+	}
+	if err := wal.Append(&Event{ID: 2, EntityID: 1}, &User{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := NewStore().replayWAL(path); err != nil || n != 1 {
+		t.Fatalf("replayed %d records after truncate: %v", n, err)
+	}
+}
+
+func TestSaveTruncatesWAL(t *testing.T) {
+	dir := t.TempDir()
+	s := newSeededStore()
+	wal, err := OpenWAL(filepath.Join(dir, "wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	s.wal = wal
+	e := newTestServer(s)
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"A","age":20,"version":1}`), http.StatusOK)
+
+	if err := s.SaveToFile(filepath.Join(dir, "store.json")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "wal")); err != nil || info.Size() != 0 {
+		t.Fatalf("wal after the save: %v, %v", info, err)
+	}
+	expectStatus(t, request(t, e, http.MethodPut, "/user/update/1", `{"id":1,"name":"B","age":20,"version":2}`), http.StatusOK)
+	if n, err := NewStore().replayWAL(filepath.Join(dir, "wal")); err != nil || n != 1 {
+		t.Fatalf("replayed %d records after the save: %v", n, err)
+	}
+}
+
+func TestSaveDuringWritesLosesNothing(t *testing.T) {
+	dir := t.TempDir()
+	s := newSeededStore()
+	wal, err := OpenWAL(filepath.Join(dir, "wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	s.wal = wal
+	e := newTestServer(s)
+
+	// saves keep running while the users are written, and the process stops
+	// right after the writes, before any save covering the last ones
+	const writers, writes = 4, 25
+	done := make(chan struct{})
+	saved := make(chan error)
+	go func() {
+		for {
+			select {
+			case <-done:
+				saved <- nil
+				return
+			default:
+			}
+			if err := s.SaveToFile(filepath.Join(dir, "store.json")); err != nil {
+				saved <- err
+				return
+			}
+		}
+	}()
+	wg := sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				id := writes*w + i + 2
+				request(t, e, http.MethodPut, fmt.Sprintf("/user/update/%d", id), fmt.Sprintf(`{"id":%d,"name":"N","age":20}`, id))
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+	if err := <-saved; err != nil {
+		t.Fatal(err)
+	}
+
+	recovered := NewStore()
+	if err := recovered.LoadFromFile(filepath.Join(dir, "store.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recovered.replayWAL(filepath.Join(dir, "wal")); err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered.users) != writers*writes+1 || len(recovered.events) != writers*writes {
+		t.Fatalf("recovered %d users and %d events after %d writes", len(recovered.users), len(recovered.events), writers*writes)
+	}
+}